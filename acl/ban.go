@@ -0,0 +1,120 @@
+package acl
+
+import (
+	"context"
+	"log"
+	"sync"
+	"time"
+)
+
+type Ban struct {
+	Key    string    `json:"key"`
+	Reason string    `json:"reason,omitempty"`
+	Until  time.Time `json:"until,omitzero"`   // 零值表示永久封禁
+	Static bool      `json:"static,omitempty"` // 来自配置文件
+}
+
+func (b Ban) expired(now time.Time) bool { return !b.Until.IsZero() && now.After(b.Until) }
+
+// BanList 按 key 精确匹配的封禁列表, 过期的记录在查询时清理
+type BanList struct {
+	mu   sync.RWMutex
+	bans map[string]Ban
+}
+
+func NewBanList() *BanList {
+	return &BanList{bans: map[string]Ban{}}
+}
+
+var KeyBans = NewBanList() // key 为 API key 名称
+
+// Ban d <= 0 表示永久封禁
+func (l *BanList) Ban(key string, d time.Duration, reason string) Ban {
+	b := Ban{Key: key, Reason: reason}
+	if d > 0 {
+		b.Until = time.Now().Add(d)
+	}
+
+	l.mu.Lock()
+	l.bans[key] = b
+	l.mu.Unlock()
+
+	log.Printf("ban %s until %v: %s", key, b.Until, reason)
+	return b
+}
+
+func (l *BanList) Unban(key string) bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if _, ok := l.bans[key]; !ok {
+		return false
+	}
+	delete(l.bans, key)
+	log.Printf("unban %s", key)
+	return true
+}
+
+func (l *BanList) IsBanned(key string) bool {
+	l.mu.RLock()
+	b, ok := l.bans[key]
+	l.mu.RUnlock()
+	if !ok {
+		return false
+	}
+
+	if b.expired(time.Now()) {
+		l.mu.Lock()
+		if b, ok := l.bans[key]; ok && b.expired(time.Now()) {
+			delete(l.bans, key)
+			log.Printf("unban %s: expired", key)
+		}
+		l.mu.Unlock()
+		return false
+	}
+	return true
+}
+
+func (l *BanList) sweep(now time.Time) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	for key, b := range l.bans {
+		if b.expired(now) {
+			delete(l.bans, key)
+			log.Printf("unban %s: expired", key)
+		}
+	}
+}
+
+// Start 定期清理过期的封禁与违规记录, ctx 取消后退出
+func Start(ctx context.Context, interval time.Duration) {
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case now := <-ticker.C:
+				Allow.sweep(now)
+				Deny.sweep(now)
+				KeyBans.sweep(now)
+				Penalties.sweep(now)
+			}
+		}
+	}()
+}
+
+func (l *BanList) List() []Ban {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+
+	now := time.Now()
+	bans := []Ban{}
+	for _, b := range l.bans {
+		if !b.expired(now) {
+			bans = append(bans, b)
+		}
+	}
+	return bans
+}
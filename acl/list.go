@@ -0,0 +1,137 @@
+package acl
+
+import (
+	"log"
+	"net/netip"
+	"sync"
+	"time"
+
+	"github.com/48Club/service_agent/cidr"
+)
+
+// List IP/CIDR 列表, 条目可以设置过期时间
+// 配置文件中的条目 (static) 在重载时整体替换, 通过管理 API 添加的条目不受影响
+type List struct {
+	name string
+	mu   sync.RWMutex
+	tree cidr.Tree[Ban]
+}
+
+func NewList(name string) *List {
+	return &List{name: name}
+}
+
+var (
+	Allow = NewList("allow") // 白名单, 跳过限速或使用 allowlist_profile
+	Deny  = NewList("deny")  // 黑名单, 在读取请求体之前返回 403
+)
+
+// Add d <= 0 表示永久有效
+func (l *List) Add(p netip.Prefix, d time.Duration, reason string) Ban {
+	b := Ban{Key: p.String(), Reason: reason}
+	if d > 0 {
+		b.Until = time.Now().Add(d)
+	}
+
+	l.mu.Lock()
+	l.tree.Insert(p, b)
+	l.mu.Unlock()
+
+	log.Printf("%s add %s until %v: %s", l.name, b.Key, b.Until, reason)
+	return b
+}
+
+func (l *List) Remove(p netip.Prefix) bool {
+	l.mu.Lock()
+	ok := l.tree.Delete(p)
+	l.mu.Unlock()
+
+	if ok {
+		log.Printf("%s remove %s", l.name, p)
+	}
+	return ok
+}
+
+// Contains addr 是否命中未过期的条目, 顺带清理过期条目
+func (l *List) Contains(addr netip.Addr) bool {
+	now := time.Now()
+	var found bool
+	var expired []netip.Prefix
+
+	l.mu.RLock()
+	l.tree.Match(addr, func(p netip.Prefix, b Ban) bool {
+		if b.expired(now) {
+			expired = append(expired, p)
+			return false
+		}
+		found = true
+		return true
+	})
+	l.mu.RUnlock()
+
+	if len(expired) > 0 {
+		l.sweep(now)
+	}
+	return found
+}
+
+// sweep 清理过期条目, 使解封在日志中及时可见
+func (l *List) sweep(now time.Time) {
+	var expired []netip.Prefix
+	l.mu.RLock()
+	l.tree.Walk(func(p netip.Prefix, b Ban) {
+		if b.expired(now) {
+			expired = append(expired, p)
+		}
+	})
+	l.mu.RUnlock()
+
+	if len(expired) == 0 {
+		return
+	}
+	l.mu.Lock()
+	for _, p := range expired {
+		if b, ok := l.tree.Get(p); ok && b.expired(now) {
+			l.tree.Delete(p)
+			log.Printf("%s expire %s", l.name, p)
+		}
+	}
+	l.mu.Unlock()
+}
+
+func (l *List) List() []Ban {
+	now := time.Now()
+	bans := []Ban{}
+
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+	l.tree.Walk(func(_ netip.Prefix, b Ban) {
+		if !b.expired(now) {
+			bans = append(bans, b)
+		}
+	})
+	return bans
+}
+
+// SetStatic 替换配置文件中的条目
+func (l *List) SetStatic(prefixes []netip.Prefix) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	var old []netip.Prefix
+	l.tree.Walk(func(p netip.Prefix, b Ban) {
+		if b.Static {
+			old = append(old, p)
+		}
+	})
+	for _, p := range old {
+		l.tree.Delete(p)
+	}
+	for _, p := range prefixes {
+		if _, ok := l.tree.Get(p); ok {
+			// 已通过管理 API 添加的同一前缀保持不变
+			continue
+		}
+		l.tree.Insert(p, Ban{Key: p.String(), Static: true})
+	}
+}
@@ -0,0 +1,33 @@
+package acl
+
+import (
+	"net/netip"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestList(t *testing.T) {
+	l := NewList("test")
+	l.SetStatic([]netip.Prefix{netip.MustParsePrefix("10.0.0.0/8")})
+	l.Add(netip.MustParsePrefix("192.168.0.0/16"), 0, "manual")
+	l.Add(netip.MustParsePrefix("10.1.0.0/16"), 0, "")
+	assert.True(t, l.Contains(netip.MustParseAddr("10.1.2.3")))
+	assert.True(t, l.Contains(netip.MustParseAddr("192.168.1.1")))
+	assert.False(t, l.Contains(netip.MustParseAddr("8.8.8.8")))
+
+	// 过期的条目不再生效, 但不影响更短的前缀
+	l.Add(netip.MustParsePrefix("172.16.0.0/16"), time.Millisecond, "temporary")
+	l.Add(netip.MustParsePrefix("10.2.0.0/16"), time.Millisecond, "temporary")
+	time.Sleep(2 * time.Millisecond)
+	assert.False(t, l.Contains(netip.MustParseAddr("172.16.0.1")))
+	assert.True(t, l.Contains(netip.MustParseAddr("10.2.0.1")))
+	assert.Len(t, l.List(), 3) // 10.0.0.0/8, 10.1.0.0/16, 192.168.0.0/16
+
+	// 重载配置只替换 static 条目
+	l.SetStatic(nil)
+	assert.True(t, l.Contains(netip.MustParseAddr("10.1.2.3")))
+	assert.False(t, l.Contains(netip.MustParseAddr("10.3.0.1")))
+	assert.True(t, l.Contains(netip.MustParseAddr("192.168.1.1")))
+}
@@ -0,0 +1,107 @@
+package acl
+
+import (
+	"sync"
+	"time"
+)
+
+// Penalty 对反复触发限速的 IP 或 key 自动封禁, 封禁时长逐级递增
+type Penalty struct {
+	mu        sync.Mutex
+	threshold int             // window 内超限 threshold 次后封禁, 0 表示不开启
+	window    time.Duration   // 统计窗口
+	durations []time.Duration // 逐级递增的封禁时长, 超过最后一级后保持最后一级
+	forget    time.Duration   // 最后一次封禁后经过 forget 无违规, 等级清零
+	offenders map[string]*offender
+}
+
+type offender struct {
+	strikes []time.Time
+	level   int
+	lastBan time.Time
+}
+
+type Offender struct {
+	Key     string    `json:"key"`
+	Strikes int       `json:"strikes"`
+	Level   int       `json:"level"`
+	LastBan time.Time `json:"last_ban,omitzero"`
+}
+
+var Penalties = &Penalty{offenders: map[string]*offender{}}
+
+// PenaltyKey API key 在违规记录中的 key, 与 IP 区分
+func PenaltyKey(key string) string { return "key:" + key }
+
+func (p *Penalty) Configure(threshold int, window time.Duration, durations []time.Duration, forget time.Duration) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.threshold, p.window, p.durations, p.forget = threshold, window, durations, forget
+}
+
+// Strike 记录一次超限, 达到阈值时清空计数并返回本次应封禁的时长与等级
+func (p *Penalty) Strike(key string) (d time.Duration, level int, ban bool) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.threshold <= 0 || len(p.durations) == 0 {
+		return
+	}
+
+	now := time.Now()
+	o, ok := p.offenders[key]
+	if !ok {
+		o = &offender{}
+		p.offenders[key] = o
+	}
+	o.strikes = append(trimBefore(o.strikes, now.Add(-p.window)), now)
+	if len(o.strikes) < p.threshold {
+		return
+	}
+
+	if !o.lastBan.IsZero() && now.Sub(o.lastBan) > p.forget {
+		o.level = 0
+	}
+	d = p.durations[min(o.level, len(p.durations)-1)]
+	o.level++
+	o.lastBan, o.strikes = now, nil
+	return d, o.level, true
+}
+
+// Forgive 清除违规记录, 管理 API 解封时调用
+func (p *Penalty) Forgive(key string) {
+	p.mu.Lock()
+	delete(p.offenders, key)
+	p.mu.Unlock()
+}
+
+func (p *Penalty) List() []Offender {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	offenders := []Offender{}
+	for key, o := range p.offenders {
+		offenders = append(offenders, Offender{key, len(o.strikes), o.level, o.lastBan})
+	}
+	return offenders
+}
+
+// sweep 清理已经没有意义的违规记录
+func (p *Penalty) sweep(now time.Time) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	for key, o := range p.offenders {
+		o.strikes = trimBefore(o.strikes, now.Add(-p.window))
+		if len(o.strikes) == 0 && (o.lastBan.IsZero() || now.Sub(o.lastBan) > p.forget) {
+			delete(p.offenders, key)
+		}
+	}
+}
+
+func trimBefore(ts []time.Time, t time.Time) []time.Time {
+	i := 0
+	for i < len(ts) && ts[i].Before(t) {
+		i++
+	}
+	return ts[i:]
+}
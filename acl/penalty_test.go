@@ -0,0 +1,34 @@
+package acl
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPenalty(t *testing.T) {
+	p := &Penalty{offenders: map[string]*offender{}}
+	_, _, ban := p.Strike("1.1.1.1")
+	assert.False(t, ban) // 未开启
+
+	p.Configure(3, time.Minute, []time.Duration{time.Minute, 10 * time.Minute, time.Hour}, 24*time.Hour)
+	for _, want := range []time.Duration{time.Minute, 10 * time.Minute, time.Hour, time.Hour} {
+		for range 2 {
+			_, _, ban = p.Strike("1.1.1.1")
+			assert.False(t, ban)
+		}
+		d, _, ban := p.Strike("1.1.1.1")
+		assert.True(t, ban)
+		assert.Equal(t, want, d)
+	}
+	assert.Len(t, p.List(), 1)
+
+	p.Forgive("1.1.1.1")
+	p.Strike("1.1.1.1")
+	p.Strike("1.1.1.1")
+	d, level, ban := p.Strike("1.1.1.1")
+	assert.True(t, ban)
+	assert.Equal(t, 1, level)
+	assert.Equal(t, time.Minute, d)
+}
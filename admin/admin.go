@@ -0,0 +1,50 @@
+package admin
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/48Club/service_agent/config"
+	"github.com/gin-gonic/gin"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+// Router 管理端口路由, 与对外的 RPC 端口分开监听
+func Router() *gin.Engine {
+	r := gin.New()
+	r.Use(gin.Recovery())
+
+	r.GET("/metrics", gin.WrapH(promhttp.Handler()))
+	r.GET("/healthz", healthz)
+	r.GET("/readyz", readyz)
+	registerAPI(r)
+
+	return r
+}
+
+var (
+	errBadIP     = errors.New("bad ip, expect an IP or a CIDR")
+	errTarget    = errors.New("exactly one of ip and key is required")
+	errLimiter   = errors.New("exactly one of ip, key and sender is required")
+	errBadSender = errors.New("bad sender, expect an address")
+)
+
+// healthz 存活探针, 进程能响应即可
+func healthz(c *gin.Context) {
+	c.String(http.StatusOK, "ok")
+}
+
+// readyz 就绪探针, 读取后台轮询缓存的上游状态
+func readyz(c *gin.Context) {
+	pool := config.Get().UpstreamPool
+	upstreams := gin.H{}
+	for _, u := range pool.Upstreams() {
+		upstreams[u.Name] = u.Health()
+	}
+
+	status := http.StatusOK
+	if !pool.Ready() {
+		status = http.StatusServiceUnavailable
+	}
+	c.JSON(status, gin.H{"ready": status == http.StatusOK, "upstreams": upstreams})
+}
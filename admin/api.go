@@ -0,0 +1,315 @@
+package admin
+
+import (
+	"crypto/subtle"
+	"log"
+	"net/http"
+	"net/netip"
+	"strings"
+	"time"
+
+	"github.com/48Club/service_agent/acl"
+	"github.com/48Club/service_agent/cidr"
+	"github.com/48Club/service_agent/config"
+	"github.com/48Club/service_agent/handler"
+	"github.com/48Club/service_agent/limit"
+	"github.com/48Club/service_agent/tools"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/gin-gonic/gin"
+)
+
+func registerAPI(r *gin.Engine) {
+	api := r.Group("/admin", checkToken)
+
+	api.GET("/limiters", listLimiters)
+	api.POST("/limiters/reset", resetLimiter)
+
+	api.GET("/bans", listBans)
+	api.POST("/bans", addBan)
+	api.DELETE("/bans", removeBan)
+
+	api.GET("/allow", listAllow)
+	api.POST("/allow", addAllow)
+	api.DELETE("/allow", removeAllow)
+
+	api.GET("/upstreams", listUpstreams)
+	api.POST("/upstreams/:name/drain", setUpstreamEnabled(false))
+	api.POST("/upstreams/:name/enable", setUpstreamEnabled(true))
+
+	api.GET("/config", dumpConfig)
+	api.POST("/config/reload", reloadConfig)
+
+	api.GET("/sessions", listSessions)
+
+	api.GET("/cdn", showCDN)
+	api.POST("/cdn/reload", reloadCDN)
+}
+
+// checkToken 校验 Authorization: Bearer <admin_token>, 未配置 token 时管理 API 不可用
+func checkToken(c *gin.Context) {
+	token := config.Get().AdminToken
+	got, ok := strings.CutPrefix(c.GetHeader("Authorization"), "Bearer ")
+	if token == "" || !ok || subtle.ConstantTimeCompare([]byte(got), []byte(token)) != 1 {
+		c.AbortWithStatus(http.StatusUnauthorized)
+		return
+	}
+}
+
+// target 管理操作的对象, ip 支持单个 IP 或 CIDR
+type target struct {
+	IP       string        `json:"ip" form:"ip"`
+	Key      string        `json:"key" form:"key"`
+	Sender   string        `json:"sender" form:"sender"` // 交易发送方, 只用于重置 sender_limit
+	Duration time.Duration `json:"duration"`             // 封禁时长, 秒, 0 为永久
+	Reason   string        `json:"reason"`
+
+	prefix netip.Prefix
+}
+
+// bind 解析请求, sender 为 true 时也接受交易发送方
+func (t *target) bind(c *gin.Context, sender bool) bool {
+	var err error
+	if c.Request.Method == http.MethodDelete {
+		err = c.ShouldBindQuery(t)
+	} else {
+		err = c.ShouldBindJSON(t)
+	}
+	if err == nil && t.IP != "" {
+		t.prefix, err = parsePrefix(t.IP)
+	}
+	n := 0
+	for _, v := range []string{t.IP, t.Key, t.Sender} {
+		if v != "" {
+			n++
+		}
+	}
+	switch {
+	case err != nil:
+	case sender && n != 1:
+		err = errLimiter
+	case !sender && (n != 1 || t.Sender != ""):
+		err = errTarget
+	case t.Sender != "" && !common.IsHexAddress(t.Sender):
+		err = errBadSender
+	}
+	if err != nil {
+		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return false
+	}
+	return true
+}
+
+// parsePrefix 单个 IPv6 地址按 /64 处理, 与限速器的粒度一致
+func parsePrefix(s string) (netip.Prefix, error) {
+	p, err := cidr.ParsePrefix(s)
+	if err != nil {
+		return p, errBadIP
+	}
+	if p.Addr().Is6() && p.Bits() == 128 && !strings.Contains(s, "/") {
+		p, _ = p.Addr().Prefix(64)
+	}
+	return p, nil
+}
+
+// limiterKey 转换为与限速器一致的格式, 只支持单个 IP 或 IPv6 /64
+func (t target) limiterKey() string {
+	if t.prefix.Addr().Is6() && t.prefix.Bits() == 64 {
+		return t.prefix.String()
+	}
+	if t.prefix.IsSingleIP() {
+		return tools.FormatIP(t.prefix.Addr().String())
+	}
+	return ""
+}
+
+func listLimiters(c *gin.Context) {
+	cfg := config.Get()
+	exceptions := map[string][]limit.Usage{}
+	for domain, exception := range cfg.ExceptionLimiterMap {
+		exceptions[domain] = exception.Limter.Snapshot()
+	}
+	profiles := map[string][]limit.Usage{}
+	for name, profile := range cfg.LimitProfiles {
+		profiles[name] = profile.Snapshot()
+	}
+	bundles := map[string][]limit.Usage{}
+	for key, limiters := range cfg.Bundle.Limits {
+		bundles[key] = limiters.Snapshot()
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"default":   limit.Limits.Snapshot(),
+		"exception": exceptions,
+		"profiles":  profiles,
+		"sender":    cfg.SenderLimit.Limits.Snapshot(),
+		"bundle":    bundles,
+	})
+}
+
+func resetLimiter(c *gin.Context) {
+	var t target
+	if !t.bind(c, true) {
+		return
+	}
+	cfg := config.Get()
+
+	if t.Sender != "" {
+		cfg.SenderLimit.Limits.Prune(common.HexToAddress(t.Sender).Hex())
+		c.Status(http.StatusNoContent)
+		return
+	}
+
+	if t.Key != "" {
+		exception, ok := cfg.ExceptionLimiterMap[t.Key]
+		if !ok {
+			c.AbortWithStatus(http.StatusNotFound)
+			return
+		}
+		exception.Limter.Reset()
+		for _, limiters := range cfg.Bundle.Limits {
+			limiters.Prune(t.Key)
+		}
+		c.Status(http.StatusNoContent)
+		return
+	}
+
+	key := t.limiterKey()
+	if key == "" {
+		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": errBadIP.Error()})
+		return
+	}
+	limit.Limits.Prune(key)
+	for _, exception := range cfg.ExceptionLimiterMap {
+		exception.Limter.Prune(key)
+	}
+	for _, profile := range cfg.LimitProfiles {
+		profile.Prune(key)
+	}
+	// 没有 API key 的 bundle 请求按 IP 计数
+	for _, limiters := range cfg.Bundle.Limits {
+		limiters.Prune(key)
+	}
+	c.Status(http.StatusNoContent)
+}
+
+func listBans(c *gin.Context) {
+	c.JSON(http.StatusOK, gin.H{"ip": acl.Deny.List(), "key": acl.KeyBans.List(), "penalties": acl.Penalties.List()})
+}
+
+func addBan(c *gin.Context) {
+	var t target
+	if !t.bind(c, false) {
+		return
+	}
+	if t.Key != "" {
+		c.JSON(http.StatusOK, acl.KeyBans.Ban(t.Key, t.Duration*time.Second, t.Reason))
+		return
+	}
+	c.JSON(http.StatusOK, acl.Deny.Add(t.prefix, t.Duration*time.Second, t.Reason))
+}
+
+func removeBan(c *gin.Context) {
+	var t target
+	if !t.bind(c, false) {
+		return
+	}
+
+	// 解封时同时清除违规记录, 避免再次触发时直接进入更高的封禁等级
+	var ok bool
+	if t.Key != "" {
+		ok = acl.KeyBans.Unban(t.Key)
+		acl.Penalties.Forgive(acl.PenaltyKey(t.Key))
+	} else {
+		ok = acl.Deny.Remove(t.prefix)
+		acl.Penalties.Forgive(t.limiterKey())
+	}
+	if !ok {
+		c.AbortWithStatus(http.StatusNotFound)
+		return
+	}
+	c.Status(http.StatusNoContent)
+}
+
+func listAllow(c *gin.Context) {
+	c.JSON(http.StatusOK, acl.Allow.List())
+}
+
+func addAllow(c *gin.Context) {
+	var t target
+	if !t.bind(c, false) {
+		return
+	}
+	if t.Key != "" {
+		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": errBadIP.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, acl.Allow.Add(t.prefix, t.Duration*time.Second, t.Reason))
+}
+
+func removeAllow(c *gin.Context) {
+	var t target
+	if !t.bind(c, false) {
+		return
+	}
+	if t.Key != "" || !acl.Allow.Remove(t.prefix) {
+		c.AbortWithStatus(http.StatusNotFound)
+		return
+	}
+	c.Status(http.StatusNoContent)
+}
+
+func listUpstreams(c *gin.Context) {
+	upstreams := []gin.H{}
+	for _, u := range config.Get().UpstreamPool.Upstreams() {
+		upstreams = append(upstreams, gin.H{"name": u.Name, "enabled": u.Enabled(), "health": u.Health()})
+	}
+	c.JSON(http.StatusOK, upstreams)
+}
+
+func setUpstreamEnabled(enabled bool) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		u := config.Get().UpstreamPool.Get(c.Param("name"))
+		if u == nil {
+			c.AbortWithStatus(http.StatusNotFound)
+			return
+		}
+		u.SetEnabled(enabled)
+		c.Status(http.StatusNoContent)
+	}
+}
+
+func dumpConfig(c *gin.Context) {
+	c.JSON(http.StatusOK, config.Get().Redacted())
+}
+
+func reloadConfig(c *gin.Context) {
+	if err := config.Reload(); err != nil {
+		c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.Status(http.StatusNoContent)
+}
+
+func listSessions(c *gin.Context) {
+	c.JSON(http.StatusOK, handler.Sessions())
+}
+
+func showCDN(c *gin.Context) {
+	edges := []gin.H{}
+	for _, e := range config.Get().Edges {
+		r := e.Ranges()
+		edges = append(edges, gin.H{"name": e.Name, "header": e.Header, "source": r.Source, "count": r.Len()})
+	}
+	c.JSON(http.StatusOK, edges)
+}
+
+// reloadCDN 只重新读取各 edge 的 IP 段文件, 不重载其他配置
+func reloadCDN(c *gin.Context) {
+	for _, e := range config.Get().Edges {
+		if err := e.Reload(); err != nil {
+			c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		log.Printf("edge %s ip list reloaded, %d ranges from %v", e.Name, e.Ranges().Len(), e.Ranges().Source)
+	}
+	showCDN(c)
+}
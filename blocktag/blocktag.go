@@ -0,0 +1,115 @@
+package blocktag
+
+import (
+	"github.com/48Club/service_agent/upstream"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+)
+
+// positions 方法 => 区块参数的位置
+var positions = map[string]int{
+	"eth_getBalance":                          1,
+	"eth_getCode":                             1,
+	"eth_getTransactionCount":                 1,
+	"eth_getStorageAt":                        2,
+	"eth_getProof":                            2,
+	"eth_call":                                1,
+	"eth_estimateGas":                         1,
+	"eth_createAccessList":                    1,
+	"eth_feeHistory":                          1,
+	"eth_getBlockByNumber":                    0,
+	"eth_getHeaderByNumber":                   0,
+	"eth_getBlockReceipts":                    0,
+	"eth_getBlockTransactionCountByNumber":    0,
+	"eth_getTransactionByBlockNumberAndIndex": 0,
+	"eth_getUncleCountByBlockNumber":          0,
+	"eth_getUncleByBlockNumberAndIndex":       0,
+	"debug_traceCall":                         1,
+	"debug_traceBlockByNumber":                0,
+}
+
+// optional 省略区块参数时节点按 latest 处理的方法
+var optional = map[string]bool{
+	"eth_call":             true,
+	"eth_estimateGas":      true,
+	"eth_createAccessList": true,
+}
+
+// Pin 将参数中的 latest, safe 和 finalized 改写为 heads 中的区块号, 返回改写后的参数和是否有改动
+// pending, earliest, 区块号和区块哈希保持不变, heads 中为 0 的标签不改写
+func Pin(method string, params []any, heads upstream.Heads) ([]any, bool) {
+	if method == "eth_getLogs" {
+		return params, pinFilter(params, heads)
+	}
+	i, ok := positions[method]
+	if !ok {
+		return params, false
+	}
+	if len(params) == i && optional[method] {
+		if heads.Latest == 0 {
+			return params, false
+		}
+		return append(params, hexutil.EncodeUint64(heads.Latest)), true
+	}
+	if len(params) <= i {
+		return params, false
+	}
+	switch v := params[i].(type) {
+	case string:
+		if n, ok := resolve(v, heads); ok {
+			params[i] = n
+			return params, true
+		}
+	case map[string]any:
+		// EIP-1898 {"blockNumber": "latest"}
+		if s, ok := v["blockNumber"].(string); ok {
+			if n, ok := resolve(s, heads); ok {
+				v["blockNumber"] = n
+				return params, true
+			}
+		}
+	}
+	return params, false
+}
+
+// pinFilter 改写 eth_getLogs 的 fromBlock 和 toBlock, 省略时按 latest 处理, 指定 blockHash 时不改写
+func pinFilter(params []any, heads upstream.Heads) bool {
+	if len(params) != 1 {
+		return false
+	}
+	filter, ok := params[0].(map[string]any)
+	if !ok {
+		return false
+	}
+	if _, ok := filter["blockHash"]; ok {
+		return false
+	}
+	changed := false
+	for _, k := range []string{"fromBlock", "toBlock"} {
+		v, ok := filter[k]
+		if !ok || v == nil {
+			v = "latest"
+		}
+		if s, ok := v.(string); ok {
+			if n, ok := resolve(s, heads); ok {
+				filter[k], changed = n, true
+			}
+		}
+	}
+	return changed
+}
+
+func resolve(tag string, heads upstream.Heads) (string, bool) {
+	var n uint64
+	switch tag {
+	case "latest":
+		n = heads.Latest
+	case "safe":
+		n = heads.Safe
+	case "finalized":
+		n = heads.Finalized
+	}
+	if n == 0 {
+		return "", false
+	}
+	return hexutil.EncodeUint64(n), true
+}
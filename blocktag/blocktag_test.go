@@ -0,0 +1,44 @@
+package blocktag
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/48Club/service_agent/upstream"
+)
+
+func TestPin(t *testing.T) {
+	heads := upstream.Heads{Latest: 0x64, Safe: 0x62, Finalized: 0x60}
+	for _, c := range []struct {
+		method  string
+		params  string
+		want    string
+		changed bool
+	}{
+		{"eth_getBalance", `["0x48","latest"]`, `["0x48","0x64"]`, true},
+		{"eth_getStorageAt", `["0x48","0x0","safe"]`, `["0x48","0x0","0x62"]`, true},
+		{"eth_getBlockByNumber", `["finalized",false]`, `["0x60",false]`, true},
+		{"eth_call", `[{"to":"0x48"}]`, `[{"to":"0x48"},"0x64"]`, true},
+		{"eth_call", `[{"to":"0x48"},{"blockNumber":"latest"}]`, `[{"to":"0x48"},{"blockNumber":"0x64"}]`, true},
+		{"eth_call", `[{"to":"0x48"},"pending"]`, `[{"to":"0x48"},"pending"]`, false},
+		{"eth_getBalance", `["0x48","0x10"]`, `["0x48","0x10"]`, false},
+		{"eth_getBalance", `["0x48"]`, `["0x48"]`, false},
+		{"eth_getLogs", `[{"fromBlock":"0x10"}]`, `[{"fromBlock":"0x10","toBlock":"0x64"}]`, true},
+		{"eth_getLogs", `[{"blockHash":"0x01"}]`, `[{"blockHash":"0x01"}]`, false},
+		{"eth_chainId", `[]`, `[]`, false},
+	} {
+		var params []any
+		json.Unmarshal([]byte(c.params), &params)
+		got, changed := Pin(c.method, params, heads)
+		data, _ := json.Marshal(got)
+		if string(data) != c.want || changed != c.changed {
+			t.Errorf("%s %s: got %s, %v", c.method, c.params, data, changed)
+		}
+	}
+
+	// 不支持 finalized 的上游存在时保持原样
+	params := []any{"finalized", false}
+	if _, changed := Pin("eth_getBlockByNumber", params, upstream.Heads{Latest: 0x64}); changed || params[0] != "finalized" {
+		t.Errorf("got %v, %v", params, changed)
+	}
+}
@@ -0,0 +1,163 @@
+package broadcast
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	"github.com/48Club/service_agent/metrics"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/rpc"
+)
+
+// Endpoint 接收交易的节点, 可以是全节点, 面向验证者的哨兵或 builder
+type Endpoint struct {
+	Name    string
+	URL     string
+	Timeout time.Duration
+	Private bool // 私有中继, 隐私模式的交易只发给私有中继
+
+	client *rpc.Client
+}
+
+func NewEndpoint(name, url string, timeout time.Duration, private bool) (*Endpoint, error) {
+	if timeout <= 0 {
+		timeout = 5 * time.Second
+	}
+	client, err := rpc.DialOptions(context.Background(), url)
+	if err != nil {
+		return nil, fmt.Errorf("broadcast endpoint %s: %w", name, err)
+	}
+	return &Endpoint{Name: name, URL: url, Timeout: timeout, Private: private, client: client}, nil
+}
+
+// Result 一个节点的发送结果
+type Result struct {
+	Endpoint string        `json:"endpoint"`
+	Private  bool          `json:"private"`
+	Accepted bool          `json:"accepted"`
+	Error    string        `json:"error,omitempty"`
+	Latency  time.Duration `json:"latency"`
+}
+
+// send 节点返回 already known 说明交易已在其交易池中, 同样视为接受
+func (e *Endpoint) send(ctx context.Context, hash common.Hash, raw []byte, onResult func(Result)) (common.Hash, error) {
+	ctx, cancel := context.WithTimeout(ctx, e.Timeout)
+	defer cancel()
+
+	start := time.Now()
+	var result common.Hash
+	err := e.client.CallContext(ctx, &result, "eth_sendRawTransaction", hexutil.Encode(raw))
+	if err != nil && strings.Contains(err.Error(), "already known") {
+		result, err = hash, nil
+	}
+
+	outcome := "accepted"
+	switch {
+	case err == nil:
+	case errors.Is(err, context.DeadlineExceeded):
+		outcome = "timeout"
+	case errors.As(err, new(rpc.Error)):
+		outcome = "rejected"
+	default:
+		outcome = "error"
+	}
+	metrics.BroadcastResults.WithLabelValues(e.Name, outcome).Inc()
+	r := Result{Endpoint: e.Name, Private: e.Private, Accepted: err == nil, Latency: time.Since(start)}
+	if err != nil {
+		r.Error = err.Error()
+		log.Printf("broadcast %s to %s: %s in %s: %v", hash, e.Name, outcome, r.Latency, err)
+	} else {
+		log.Printf("broadcast %s to %s: %s in %s", hash, e.Name, outcome, r.Latency)
+	}
+	if onResult != nil {
+		onResult(r)
+	}
+	return result, err
+}
+
+// Broadcaster 把交易并发发送到多个节点
+type Broadcaster struct {
+	Endpoints   []*Endpoint
+	MinAccepted int // 至少多少个节点接受才算成功, 默认 1, 不超过发送的节点数
+}
+
+// Public 隐私模式回退时使用的公开节点
+func (b *Broadcaster) Public() []*Endpoint {
+	return b.filter(false)
+}
+
+// Private 隐私模式使用的私有中继
+func (b *Broadcaster) Private() []*Endpoint {
+	return b.filter(true)
+}
+
+func (b *Broadcaster) filter(private bool) []*Endpoint {
+	endpoints := []*Endpoint{}
+	for _, e := range b.Endpoints {
+		if e.Private == private {
+			endpoints = append(endpoints, e)
+		}
+	}
+	return endpoints
+}
+
+// ErrNotEnough 接受的节点数未达到 MinAccepted, 交易可能已经传播出去
+var ErrNotEnough = errors.New("transaction not accepted by enough endpoints")
+
+// Send 发送到 endpoints, 至少 MinAccepted 个节点接受后返回第一个成功的哈希, 不等待其余节点
+// 发送不随客户端请求取消, 每个节点的结果都会记录并回调 onResult
+// 没有足够节点接受时, 优先返回节点的 JSON-RPC 错误, 便于客户端处理 nonce too low 等情况
+func (b *Broadcaster) Send(ctx context.Context, endpoints []*Endpoint, hash common.Hash, raw []byte, onResult func(Result)) (common.Hash, error) {
+	if len(endpoints) == 0 {
+		return common.Hash{}, fmt.Errorf("%w: no endpoints", ErrNotEnough)
+	}
+	type outcome struct {
+		hash common.Hash
+		err  error
+	}
+	ch := make(chan outcome, len(endpoints))
+	bg := context.WithoutCancel(ctx)
+	for _, e := range endpoints {
+		go func() {
+			h, err := e.send(bg, hash, raw, onResult)
+			ch <- outcome{h, err}
+		}()
+	}
+
+	minAccepted := min(max(b.MinAccepted, 1), len(endpoints))
+	var (
+		accepted int
+		first    common.Hash
+		rpcErr   error
+	)
+	for range endpoints {
+		var o outcome
+		select {
+		case o = <-ch:
+		case <-ctx.Done():
+			return common.Hash{}, ctx.Err()
+		}
+		if o.err != nil {
+			if rpcErr == nil && errors.As(o.err, new(rpc.Error)) {
+				rpcErr = o.err
+			}
+			continue
+		}
+		if accepted++; accepted == 1 {
+			first = o.hash
+		}
+		if accepted >= minAccepted {
+			return first, nil
+		}
+	}
+
+	if rpcErr != nil && accepted == 0 {
+		return common.Hash{}, rpcErr
+	}
+	return common.Hash{}, fmt.Errorf("%w: %d of %d accepted, %d required", ErrNotEnough, accepted, len(endpoints), minAccepted)
+}
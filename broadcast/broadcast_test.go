@@ -0,0 +1,70 @@
+package broadcast
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/rpc"
+)
+
+// node 模拟节点, delay 后返回 result 或 error
+func node(t *testing.T, delay time.Duration, result, errMsg string) *Endpoint {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.ReadAll(r.Body)
+		time.Sleep(delay)
+		w.Header().Set("Content-Type", "application/json")
+		if errMsg != "" {
+			fmt.Fprintf(w, `{"jsonrpc":"2.0","id":1,"error":{"code":-32000,"message":%q}}`, errMsg)
+			return
+		}
+		fmt.Fprintf(w, `{"jsonrpc":"2.0","id":1,"result":%q}`, result)
+	}))
+	t.Cleanup(srv.Close)
+	e, err := NewEndpoint(srv.URL, srv.URL, 200*time.Millisecond, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return e
+}
+
+func TestSend(t *testing.T) {
+	hash := common.HexToHash("0x48")
+	ok := node(t, 0, hash.Hex(), "")
+	known := node(t, 50*time.Millisecond, "", "already known")
+	low := node(t, 0, "", "nonce too low")
+	slow := node(t, 300*time.Millisecond, hash.Hex(), "")
+
+	for _, c := range []struct {
+		name        string
+		endpoints   []*Endpoint
+		minAccepted int
+		wantErr     string
+	}{
+		{"first success", []*Endpoint{low, ok, slow}, 1, ""},
+		{"already known counts", []*Endpoint{ok, known}, 2, ""},
+		{"rpc error kept", []*Endpoint{low, slow}, 1, "nonce too low"},
+		{"not enough", []*Endpoint{ok, low, slow}, 2, ErrNotEnough.Error()},
+	} {
+		b := &Broadcaster{Endpoints: c.endpoints, MinAccepted: c.minAccepted}
+		got, err := b.Send(context.Background(), b.Endpoints, hash, []byte{1}, nil)
+		if c.wantErr == "" {
+			if err != nil || got != hash {
+				t.Errorf("%s: got %s, %v", c.name, got, err)
+			}
+			continue
+		}
+		if err == nil || !errors.Is(err, ErrNotEnough) && err.Error() != c.wantErr {
+			t.Errorf("%s: got %v, want %s", c.name, err, c.wantErr)
+		}
+		if c.wantErr == "nonce too low" && !errors.As(err, new(rpc.Error)) {
+			t.Errorf("%s: want rpc.Error, got %T", c.name, err)
+		}
+	}
+}
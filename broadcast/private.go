@@ -0,0 +1,233 @@
+package broadcast
+
+import (
+	"cmp"
+	"context"
+	"errors"
+	"log"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/ethereum/go-ethereum"
+	"github.com/ethereum/go-ethereum/common"
+	ethtypes "github.com/ethereum/go-ethereum/core/types"
+)
+
+// 隐私模式交易的状态
+const (
+	StatusPrivate  = "private"  // 只发送给了私有中继
+	StatusPublic   = "public"   // 超过 fallback_blocks 未上链, 已回退为公开广播
+	StatusIncluded = "included" // 已上链
+	StatusFailed   = "failed"   // 私有中继全部未接受
+)
+
+// retention 记录保留时长, 超过后 eth_getPrivateTransactionStatus 返回 null
+const retention = time.Hour
+
+// concurrency 每轮并发查询回执的交易数
+const concurrency = 16
+
+// Chain 查询链上状态, 由上游节点的 ethclient 实现
+type Chain interface {
+	BlockNumber(ctx context.Context) (uint64, error)
+	TransactionReceipt(ctx context.Context, hash common.Hash) (*ethtypes.Receipt, error)
+	SendTransaction(ctx context.Context, tx *ethtypes.Transaction) error
+}
+
+// Status 隐私模式交易的发送记录, 不包含来源域名等信息
+type Status struct {
+	Hash           common.Hash `json:"hash"`
+	Status         string      `json:"status"`
+	SentAt         time.Time   `json:"sent_at"`
+	SentBlock      uint64      `json:"sent_block"`               // 发送时的最新区块, 尚未轮询到时为 0
+	FallbackBlocks uint64      `json:"fallback_blocks"`          // 0 表示不回退
+	FallbackBlock  uint64      `json:"fallback_block,omitempty"` // 回退为公开广播时的区块
+	IncludedBlock  uint64      `json:"included_block,omitempty"`
+	Results        []Result    `json:"results"` // 每个节点的发送结果, 包括回退后的公开节点
+
+	raw    []byte
+	caller string // 提交方, 只有提交方可以查询
+}
+
+// Tracker 跟踪隐私模式的交易, 未在 fallback_blocks 个区块内上链时回退为公开广播
+type Tracker struct {
+	mu   sync.Mutex
+	txs  map[common.Hash]*Status
+	head uint64
+}
+
+func NewTracker() *Tracker {
+	return &Tracker{txs: map[common.Hash]*Status{}}
+}
+
+var PrivateTxs = NewTracker() // 跨配置重载保留
+
+// Track 在发送前登记交易, 返回的回调用于记录各节点的发送结果
+// caller 为提交方的 API key 或 IP, Get 时需要一致
+func (t *Tracker) Track(hash common.Hash, raw []byte, fallbackBlocks uint64, caller string) func(Result) {
+	t.mu.Lock()
+	t.txs[hash] = &Status{Hash: hash, Status: StatusPrivate, SentAt: time.Now(), SentBlock: t.head, FallbackBlocks: fallbackBlocks, raw: raw, caller: caller}
+	t.mu.Unlock()
+	return t.record(hash)
+}
+
+func (t *Tracker) record(hash common.Hash) func(Result) {
+	return func(r Result) {
+		t.mu.Lock()
+		defer t.mu.Unlock()
+		if s, ok := t.txs[hash]; ok {
+			s.Results = append(s.Results, r)
+		}
+	}
+}
+
+// Fail 私有中继未接受, 交易不会回退为公开广播
+func (t *Tracker) Fail(hash common.Hash) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if s, ok := t.txs[hash]; ok && s.Status == StatusPrivate {
+		s.Status = StatusFailed
+	}
+}
+
+// Get 返回交易状态的副本, 不是 caller 提交的交易按不存在处理
+func (t *Tracker) Get(hash common.Hash, caller string) (Status, bool) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	s, ok := t.txs[hash]
+	if !ok || s.caller != caller {
+		return Status{}, false
+	}
+	c := *s
+	c.Results = slices.Clone(s.Results)
+	return c, true
+}
+
+// Start 每个 interval 查询最新区块和交易回执, ctx 取消后退出
+// chain 每次调用时获取, 以便使用当前健康的上游; public 返回当前配置的广播节点, 为 nil 或没有公开节点时回退发送给上游
+func (t *Tracker) Start(ctx context.Context, interval time.Duration, chain func(context.Context) (Chain, error), public func() *Broadcaster) {
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			}
+			c, err := chain(ctx)
+			if err != nil {
+				log.Printf("private tx: %v", err)
+				continue
+			}
+			t.check(ctx, interval, c, public)
+		}
+	}()
+}
+
+// check 每次上游调用单独计时, 已到回退区块的交易优先查询
+func (t *Tracker) check(ctx context.Context, interval time.Duration, c Chain, public func() *Broadcaster) {
+	headCtx, cancel := context.WithTimeout(ctx, interval)
+	head, err := c.BlockNumber(headCtx)
+	cancel()
+	if err != nil {
+		log.Printf("private tx: block number: %v", err)
+		return
+	}
+
+	type pending struct {
+		hash     common.Hash
+		raw      []byte
+		blocks   uint64 // 发送后经过的区块数
+		fallback bool
+	}
+	var todo []pending
+	now := time.Now()
+	t.mu.Lock()
+	t.head = head
+	for hash, s := range t.txs {
+		if now.Sub(s.SentAt) > retention {
+			delete(t.txs, hash)
+			continue
+		}
+		if s.SentBlock == 0 {
+			s.SentBlock = head
+		}
+		if s.Status == StatusPrivate || s.Status == StatusPublic {
+			fallback := s.Status == StatusPrivate && s.FallbackBlocks > 0 && head >= s.SentBlock+s.FallbackBlocks
+			todo = append(todo, pending{hash, s.raw, head - min(s.SentBlock, head), fallback})
+		}
+	}
+	t.mu.Unlock()
+	slices.SortFunc(todo, func(a, b pending) int {
+		if a.fallback != b.fallback {
+			if a.fallback {
+				return -1
+			}
+			return 1
+		}
+		return cmp.Compare(b.blocks, a.blocks)
+	})
+
+	var wg sync.WaitGroup
+	sem := make(chan struct{}, concurrency)
+	for _, p := range todo {
+		sem <- struct{}{}
+		wg.Go(func() {
+			defer func() { <-sem }()
+			ctx, cancel := context.WithTimeout(ctx, interval)
+			defer cancel()
+
+			receipt, err := c.TransactionReceipt(ctx, p.hash)
+			switch {
+			case err == nil:
+				t.update(p.hash, func(s *Status) {
+					s.Status, s.IncludedBlock = StatusIncluded, receipt.BlockNumber.Uint64()
+				})
+				return
+			case !errors.Is(err, ethereum.NotFound):
+				log.Printf("private tx %s: receipt: %v", p.hash, err)
+				return
+			}
+			if !p.fallback {
+				return
+			}
+
+			log.Printf("private tx %s: not included after %d blocks, broadcasting publicly", p.hash, p.blocks)
+			t.update(p.hash, func(s *Status) {
+				s.Status, s.FallbackBlock = StatusPublic, head
+			})
+			if b := public(); b != nil && len(b.Public()) > 0 {
+				go b.Send(context.WithoutCancel(ctx), b.Public(), p.hash, p.raw, t.record(p.hash))
+				return
+			}
+			t.sendUpstream(ctx, c, p.hash, p.raw)
+		})
+	}
+	wg.Wait()
+}
+
+// sendUpstream 没有配置公开节点时通过上游节点广播
+func (t *Tracker) sendUpstream(ctx context.Context, c Chain, hash common.Hash, raw []byte) {
+	tx := new(ethtypes.Transaction)
+	if err := tx.UnmarshalBinary(raw); err != nil {
+		return
+	}
+	start := time.Now()
+	err := c.SendTransaction(ctx, tx)
+	r := Result{Endpoint: "upstream", Accepted: err == nil, Latency: time.Since(start)}
+	if err != nil {
+		r.Error = err.Error()
+		log.Printf("private tx %s: upstream: %v", hash, err)
+	}
+	t.record(hash)(r)
+}
+
+func (t *Tracker) update(hash common.Hash, f func(*Status)) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if s, ok := t.txs[hash]; ok {
+		f(s)
+	}
+}
@@ -0,0 +1,76 @@
+package broadcast
+
+import (
+	"context"
+	"math/big"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/ethereum/go-ethereum"
+	"github.com/ethereum/go-ethereum/common"
+	ethtypes "github.com/ethereum/go-ethereum/core/types"
+)
+
+type fakeChain struct {
+	mu       sync.Mutex
+	head     uint64
+	included map[common.Hash]uint64
+	sent     []common.Hash
+}
+
+func (c *fakeChain) BlockNumber(context.Context) (uint64, error) { return c.head, nil }
+
+func (c *fakeChain) TransactionReceipt(_ context.Context, hash common.Hash) (*ethtypes.Receipt, error) {
+	if n, ok := c.included[hash]; ok {
+		return &ethtypes.Receipt{BlockNumber: new(big.Int).SetUint64(n)}, nil
+	}
+	return nil, ethereum.NotFound
+}
+
+func (c *fakeChain) SendTransaction(_ context.Context, tx *ethtypes.Transaction) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.sent = append(c.sent, tx.Hash())
+	return nil
+}
+
+func TestTrackerFallback(t *testing.T) {
+	tx := ethtypes.NewTx(&ethtypes.LegacyTx{Nonce: 1, GasPrice: big.NewInt(1), Gas: 21000})
+	raw, _ := tx.MarshalBinary()
+	other := common.HexToHash("0x48")
+
+	chain := &fakeChain{head: 100, included: map[common.Hash]uint64{}}
+	tr := NewTracker()
+	tr.Track(tx.Hash(), raw, 3, "key")
+	tr.Track(other, nil, 0, "key")
+	nobody := func() *Broadcaster { return nil }
+
+	tr.check(context.Background(), time.Second, chain, nobody)
+	if s, _ := tr.Get(tx.Hash(), "key"); s.Status != StatusPrivate || s.SentBlock != 100 {
+		t.Fatalf("got %+v", s)
+	}
+
+	chain.head = 103
+	tr.check(context.Background(), time.Second, chain, nobody)
+	s, _ := tr.Get(tx.Hash(), "key")
+	if s.Status != StatusPublic || s.FallbackBlock != 103 || len(chain.sent) != 1 || len(s.Results) != 1 {
+		t.Fatalf("fallback: got %+v, sent %v", s, chain.sent)
+	}
+	if s, _ := tr.Get(other, "key"); s.Status != StatusPrivate {
+		t.Fatalf("no fallback configured: got %s", s.Status)
+	}
+
+	chain.included[tx.Hash()] = 104
+	chain.head = 104
+	tr.check(context.Background(), time.Second, chain, nobody)
+	if s, _ := tr.Get(tx.Hash(), "key"); s.Status != StatusIncluded || s.IncludedBlock != 104 {
+		t.Fatalf("included: got %+v", s)
+	}
+	if _, ok := tr.Get(common.HexToHash("0x1"), "key"); ok {
+		t.Fatal("unknown tx found")
+	}
+	if _, ok := tr.Get(tx.Hash(), "other"); ok {
+		t.Fatal("tx visible to another caller")
+	}
+}
@@ -0,0 +1,193 @@
+package bundle
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/48Club/service_agent/metrics"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/ethereum/go-ethereum/rpc"
+)
+
+const (
+	MethodSend   = "eth_sendBundle"
+	MethodCall   = "eth_callBundle"
+	MethodCancel = "eth_cancelBundle"
+)
+
+// IsBundle 是否为 bundle 相关方法
+func IsBundle(method string) bool {
+	return method == MethodSend || method == MethodCall || method == MethodCancel
+}
+
+// Args bundle 方法的参数, 只解析需要校验的字段, 转发时使用原始参数
+type Args struct {
+	Txs               []hexutil.Bytes `json:"txs"`
+	BlockNumber       *hexutil.Uint64 `json:"blockNumber"`
+	MaxBlockNumber    uint64          `json:"maxBlockNumber"`
+	MinTimestamp      uint64          `json:"minTimestamp"`
+	MaxTimestamp      uint64          `json:"maxTimestamp"`
+	RevertingTxHashes []common.Hash   `json:"revertingTxHashes"`
+	ReplacementUUID   string          `json:"replacementUuid"`
+}
+
+// ParseArgs 解析并检查 bundle 结构, 交易内容由调用方逐笔校验
+func ParseArgs(method string, params []any, maxTxs int) (*Args, error) {
+	if len(params) != 1 {
+		return nil, fmt.Errorf("expected 1 argument, got %d", len(params))
+	}
+	data, err := json.Marshal(params[0])
+	if err != nil {
+		return nil, err
+	}
+	args := new(Args)
+	if err := json.Unmarshal(data, args); err != nil {
+		return nil, fmt.Errorf("invalid argument 0: %w", err)
+	}
+
+	if method == MethodCancel {
+		if args.ReplacementUUID == "" {
+			return nil, errors.New("missing replacementUuid")
+		}
+		return args, nil
+	}
+
+	if len(args.Txs) == 0 {
+		return nil, errors.New("bundle missing txs")
+	}
+	if maxTxs > 0 && len(args.Txs) > maxTxs {
+		return nil, fmt.Errorf("bundle has %d txs, limit %d", len(args.Txs), maxTxs)
+	}
+	if args.MaxTimestamp != 0 && args.MaxTimestamp < args.MinTimestamp {
+		return nil, fmt.Errorf("maxTimestamp %d before minTimestamp %d", args.MaxTimestamp, args.MinTimestamp)
+	}
+	return args, nil
+}
+
+// Hash bundle 哈希, 为所有交易哈希拼接后的 keccak256, 与 builder 的计算方式一致
+func Hash(txs []common.Hash) common.Hash {
+	data := make([]byte, 0, len(txs)*common.HashLength)
+	for _, h := range txs {
+		data = append(data, h.Bytes()...)
+	}
+	return crypto.Keccak256Hash(data)
+}
+
+// Builder 接收 bundle 的 builder
+type Builder struct {
+	Name    string
+	URL     string
+	Timeout time.Duration
+
+	client *rpc.Client
+}
+
+func NewBuilder(name, url string, timeout time.Duration) (*Builder, error) {
+	if timeout <= 0 {
+		timeout = 5 * time.Second
+	}
+	client, err := rpc.DialOptions(context.Background(), url)
+	if err != nil {
+		return nil, fmt.Errorf("bundle builder %s: %w", name, err)
+	}
+	return &Builder{Name: name, URL: url, Timeout: timeout, client: client}, nil
+}
+
+func (b *Builder) call(ctx context.Context, method string, params []any) (json.RawMessage, error) {
+	ctx, cancel := context.WithTimeout(ctx, b.Timeout)
+	defer cancel()
+
+	start := time.Now()
+	var result json.RawMessage
+	err := b.client.CallContext(ctx, &result, method, params...)
+
+	outcome := "accepted"
+	switch {
+	case err == nil:
+	case errors.Is(err, context.DeadlineExceeded):
+		outcome = "timeout"
+	case errors.As(err, new(rpc.Error)):
+		outcome = "rejected"
+	default:
+		outcome = "error"
+	}
+	metrics.BundleResults.WithLabelValues(b.Name, method, outcome).Inc()
+	if err != nil {
+		log.Printf("bundle %s to %s: %s in %s: %v", method, b.Name, outcome, time.Since(start), err)
+	}
+	return result, err
+}
+
+// Result 一个 builder 的响应
+type Result struct {
+	Builder  string          `json:"builder"`
+	Accepted bool            `json:"accepted"`
+	Result   json.RawMessage `json:"result,omitempty"`
+	Error    string          `json:"error,omitempty"`
+}
+
+// Consolidated eth_sendBundle 和 eth_cancelBundle 的合并结果
+type Consolidated struct {
+	BundleHash common.Hash `json:"bundleHash,omitzero"`
+	Accepted   int         `json:"accepted"`
+	Builders   []Result    `json:"builders"`
+}
+
+type Builders []*Builder
+
+// Send 并发发送给所有 builder, 等待全部返回后合并结果
+// 没有 builder 接受时返回第一个 JSON-RPC 错误, 便于客户端处理
+func (bs Builders) Send(ctx context.Context, method string, params []any) (*Consolidated, error) {
+	c := &Consolidated{Builders: make([]Result, len(bs))}
+	errs := make([]error, len(bs))
+	var wg sync.WaitGroup
+	for i, b := range bs {
+		wg.Go(func() {
+			result, err := b.call(ctx, method, params)
+			errs[i] = err
+			c.Builders[i] = Result{Builder: b.Name, Accepted: err == nil, Result: result}
+			if err != nil {
+				c.Builders[i].Error = err.Error()
+			}
+		})
+	}
+	wg.Wait()
+
+	for _, r := range c.Builders {
+		if r.Accepted {
+			c.Accepted++
+		}
+	}
+	if c.Accepted == 0 {
+		for _, err := range errs {
+			if errors.As(err, new(rpc.Error)) {
+				return nil, err
+			}
+		}
+		return nil, fmt.Errorf("bundle not accepted by any builder: %w", errors.Join(errs...))
+	}
+	return c, nil
+}
+
+// Call 依次请求 builder, 返回第一个成功的结果, 用于 eth_callBundle 模拟执行
+// builder 返回 JSON-RPC 错误说明 bundle 本身有问题, 不再尝试其他 builder
+func (bs Builders) Call(ctx context.Context, method string, params []any) (json.RawMessage, error) {
+	var err error
+	for _, b := range bs {
+		var result json.RawMessage
+		if result, err = b.call(ctx, method, params); err == nil || errors.As(err, new(rpc.Error)) {
+			return result, err
+		}
+	}
+	if err == nil {
+		err = errors.New("no bundle builders")
+	}
+	return nil, err
+}
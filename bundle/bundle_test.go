@@ -0,0 +1,30 @@
+package bundle
+
+import (
+	"testing"
+)
+
+func TestParseArgs(t *testing.T) {
+	tx := "0x01"
+	for _, c := range []struct {
+		name    string
+		method  string
+		params  []any
+		wantErr bool
+	}{
+		{"ok", MethodSend, []any{map[string]any{"txs": []any{tx, tx}, "maxBlockNumber": 100}}, false},
+		{"block number", MethodCall, []any{map[string]any{"txs": []any{tx}, "blockNumber": "0x10"}}, false},
+		{"no params", MethodSend, nil, true},
+		{"no txs", MethodSend, []any{map[string]any{}}, true},
+		{"too many txs", MethodSend, []any{map[string]any{"txs": []any{tx, tx, tx}}}, true},
+		{"bad tx", MethodSend, []any{map[string]any{"txs": []any{"zz"}}}, true},
+		{"timestamps", MethodSend, []any{map[string]any{"txs": []any{tx}, "minTimestamp": 10, "maxTimestamp": 5}}, true},
+		{"cancel", MethodCancel, []any{map[string]any{"replacementUuid": "u"}}, false},
+		{"cancel no uuid", MethodCancel, []any{map[string]any{"txs": []any{tx}}}, true},
+	} {
+		_, err := ParseArgs(c.method, c.params, 2)
+		if (err != nil) != c.wantErr {
+			t.Errorf("%s: got %v", c.name, err)
+		}
+	}
+}
@@ -0,0 +1,65 @@
+package cidr
+
+import (
+	"fmt"
+	"net/netip"
+	"os"
+	"strings"
+)
+
+// Set 只读的 IP 段集合, 如 CDN 节点列表
+type Set struct {
+	tree   Tree[struct{}]
+	Source []string // 来源文件
+}
+
+// ParseSet 每行一个 CIDR, 空行与 # 开头的行忽略
+func ParseSet(source []string, lists ...[]byte) (*Set, error) {
+	s := &Set{Source: source}
+	for _, list := range lists {
+		for _, line := range strings.Split(string(list), "\n") {
+			line = strings.TrimSpace(line)
+			if line == "" || strings.HasPrefix(line, "#") {
+				continue
+			}
+			p, err := ParsePrefix(line)
+			if err != nil {
+				return nil, fmt.Errorf("bad cidr %q: %w", line, err)
+			}
+			s.tree.Insert(p, struct{}{})
+		}
+	}
+	if s.tree.Len() == 0 {
+		return nil, fmt.Errorf("empty ip list: %v", source)
+	}
+	return s, nil
+}
+
+// LoadSet 从本地文件读取 IP 段
+func LoadSet(files []string) (*Set, error) {
+	lists := [][]byte{}
+	for _, file := range files {
+		b, err := os.ReadFile(file)
+		if err != nil {
+			return nil, err
+		}
+		lists = append(lists, b)
+	}
+	return ParseSet(files, lists...)
+}
+
+// SetOf 由已解析的前缀构造集合
+func SetOf(prefixes []netip.Prefix) *Set {
+	s := &Set{}
+	for _, p := range prefixes {
+		s.tree.Insert(p, struct{}{})
+	}
+	return s
+}
+
+func (s *Set) Len() int { return s.tree.Len() }
+
+func (s *Set) Contains(addr netip.Addr) bool {
+	_, ok := s.tree.Lookup(addr)
+	return ok
+}
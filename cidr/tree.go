@@ -0,0 +1,183 @@
+package cidr
+
+import (
+	"net/netip"
+)
+
+// Tree 按位存储的前缀树, IPv4 与 IPv6 分开存储, 查询耗时只与前缀长度有关
+// 非并发安全, 由调用方加锁
+type Tree[V any] struct {
+	v4, v6 *node[V]
+	size   int
+}
+
+type node[V any] struct {
+	child [2]*node[V]
+	val   V
+	set   bool
+}
+
+func (t *Tree[V]) root(addr netip.Addr, create bool) *node[V] {
+	r := &t.v6
+	if addr.Is4() {
+		r = &t.v4
+	}
+	if *r == nil && create {
+		*r = &node[V]{}
+	}
+	return *r
+}
+
+func bit(b []byte, i int) int { return int(b[i/8]>>(7-i%8)) & 1 }
+
+// Insert 插入或覆盖前缀, IPv4-mapped IPv6 地址按 IPv4 处理
+func (t *Tree[V]) Insert(p netip.Prefix, v V) {
+	p = normalize(p)
+	n := t.root(p.Addr(), true)
+	b := p.Addr().AsSlice()
+	for i := range p.Bits() {
+		c := bit(b, i)
+		if n.child[c] == nil {
+			n.child[c] = &node[V]{}
+		}
+		n = n.child[c]
+	}
+	if !n.set {
+		t.size++
+	}
+	n.val, n.set = v, true
+}
+
+func (t *Tree[V]) find(p netip.Prefix) *node[V] {
+	p = normalize(p)
+	n := t.root(p.Addr(), false)
+	b := p.Addr().AsSlice()
+	for i := 0; n != nil && i < p.Bits(); i++ {
+		n = n.child[bit(b, i)]
+	}
+	if n == nil || !n.set {
+		return nil
+	}
+	return n
+}
+
+// Get 精确查找前缀
+func (t *Tree[V]) Get(p netip.Prefix) (v V, ok bool) {
+	if n := t.find(p); n != nil {
+		return n.val, true
+	}
+	return
+}
+
+// Delete 删除前缀, 不存在时返回 false, 不再有前缀的分支一并删除
+func (t *Tree[V]) Delete(p netip.Prefix) bool {
+	p = normalize(p)
+	r := &t.v6
+	if p.Addr().Is4() {
+		r = &t.v4
+	}
+	// path[i] 为指向第 i 层节点的指针, 用于回溯时摘除空节点
+	path := []**node[V]{r}
+	b := p.Addr().AsSlice()
+	for i := 0; *path[i] != nil && i < p.Bits(); i++ {
+		path = append(path, &(*path[i]).child[bit(b, i)])
+	}
+	n := *path[len(path)-1]
+	if n == nil || !n.set {
+		return false
+	}
+	var zero V
+	n.val, n.set = zero, false
+	t.size--
+
+	for i := len(path) - 1; i >= 0; i-- {
+		if n = *path[i]; n.set || n.child[0] != nil || n.child[1] != nil {
+			break
+		}
+		*path[i] = nil
+	}
+	return true
+}
+
+// Lookup 最长前缀匹配
+func (t *Tree[V]) Lookup(addr netip.Addr) (v V, ok bool) {
+	t.Match(addr, func(_ netip.Prefix, val V) bool {
+		v, ok = val, true
+		return true
+	})
+	return
+}
+
+// Match 从长到短遍历所有包含 addr 的前缀, fn 返回 true 时停止
+func (t *Tree[V]) Match(addr netip.Addr, fn func(netip.Prefix, V) bool) {
+	addr = addr.Unmap()
+	if !addr.IsValid() {
+		return
+	}
+	n := t.root(addr, false)
+	b := addr.AsSlice()
+	var path []*node[V]
+	for i := 0; n != nil; i++ {
+		path = append(path, n)
+		if i == addr.BitLen() {
+			break
+		}
+		n = n.child[bit(b, i)]
+	}
+	for bits := len(path) - 1; bits >= 0; bits-- {
+		if path[bits].set {
+			p, _ := addr.Prefix(bits)
+			if fn(p, path[bits].val) {
+				return
+			}
+		}
+	}
+}
+
+func (t *Tree[V]) Len() int { return t.size }
+
+// Walk 遍历所有前缀
+func (t *Tree[V]) Walk(fn func(netip.Prefix, V)) {
+	walk(t.v4, make([]byte, 4), 0, fn)
+	walk(t.v6, make([]byte, 16), 0, fn)
+}
+
+func walk[V any](n *node[V], b []byte, depth int, fn func(netip.Prefix, V)) {
+	if n == nil {
+		return
+	}
+	if n.set {
+		addr, _ := netip.AddrFromSlice(b)
+		fn(netip.PrefixFrom(addr, depth), n.val)
+	}
+	for c := range 2 {
+		if n.child[c] == nil {
+			continue
+		}
+		nb := append([]byte{}, b...)
+		if c == 1 {
+			nb[depth/8] |= 1 << (7 - depth%8)
+		}
+		walk(n.child[c], nb, depth+1, fn)
+	}
+}
+
+func normalize(p netip.Prefix) netip.Prefix {
+	if p.Addr().Is4In6() && p.Bits() >= 96 {
+		p = netip.PrefixFrom(p.Addr().Unmap(), p.Bits()-96)
+	}
+	return p.Masked()
+}
+
+// ParsePrefix 解析 CIDR, 单个 IP 视为 /32 或 /128
+func ParsePrefix(s string) (netip.Prefix, error) {
+	if p, err := netip.ParsePrefix(s); err == nil {
+		return normalize(p), nil
+	}
+	addr, err := netip.ParseAddr(s)
+	if err != nil {
+		return netip.Prefix{}, err
+	}
+	addr = addr.Unmap()
+	return netip.PrefixFrom(addr, addr.BitLen()), nil
+}
@@ -0,0 +1,120 @@
+package cidr
+
+import (
+	"net/netip"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestTree(t *testing.T) {
+	var tree Tree[string]
+	for _, s := range []string{"10.0.0.0/8", "10.1.0.0/16", "2001:db8::/32", "2001:db8:1::/48", "0.0.0.0/0"} {
+		tree.Insert(netip.MustParsePrefix(s), s)
+	}
+	assert.Equal(t, 5, tree.Len())
+
+	lookup := func(ip string) string {
+		v, _ := tree.Lookup(netip.MustParseAddr(ip))
+		return v
+	}
+	assert.Equal(t, "10.1.0.0/16", lookup("10.1.2.3"))
+	assert.Equal(t, "10.0.0.0/8", lookup("10.2.2.3"))
+	assert.Equal(t, "10.1.0.0/16", lookup("::ffff:10.1.2.3"))
+	assert.Equal(t, "0.0.0.0/0", lookup("8.8.8.8"))
+	assert.Equal(t, "2001:db8:1::/48", lookup("2001:db8:1::1"))
+	assert.Equal(t, "2001:db8::/32", lookup("2001:db8:2::1"))
+	assert.Equal(t, "", lookup("2001:db9::1"))
+
+	var matched []string
+	tree.Match(netip.MustParseAddr("10.1.2.3"), func(p netip.Prefix, _ string) bool {
+		matched = append(matched, p.String())
+		return false
+	})
+	assert.Equal(t, []string{"10.1.0.0/16", "10.0.0.0/8", "0.0.0.0/0"}, matched)
+
+	v, ok := tree.Get(netip.MustParsePrefix("10.1.0.0/16"))
+	assert.True(t, ok)
+	assert.Equal(t, "10.1.0.0/16", v)
+	_, ok = tree.Get(netip.MustParsePrefix("10.1.0.0/17"))
+	assert.False(t, ok)
+
+	assert.True(t, tree.Delete(netip.MustParsePrefix("10.1.0.0/16")))
+	assert.False(t, tree.Delete(netip.MustParsePrefix("10.1.0.0/16")))
+	assert.Equal(t, "10.0.0.0/8", lookup("10.1.2.3"))
+
+	var walked []string
+	tree.Walk(func(p netip.Prefix, v string) {
+		assert.Equal(t, v, p.String())
+		walked = append(walked, v)
+	})
+	assert.ElementsMatch(t, []string{"0.0.0.0/0", "10.0.0.0/8", "2001:db8::/32", "2001:db8:1::/48"}, walked)
+}
+
+func countNodes[V any](n *node[V]) int {
+	if n == nil {
+		return 0
+	}
+	return 1 + countNodes(n.child[0]) + countNodes(n.child[1])
+}
+
+func TestTreeDeletePrunes(t *testing.T) {
+	var tree Tree[bool]
+	tree.Insert(netip.MustParsePrefix("10.0.0.0/8"), true)
+	tree.Insert(netip.MustParsePrefix("2001:db8::/32"), true)
+	v4, v6 := countNodes(tree.v4), countNodes(tree.v6)
+
+	// 自动封禁插入的 /32 和 /64 删除后不保留分支
+	bans := []string{"10.1.2.3/32", "10.1.2.4/32", "1.2.3.4/32", "2001:db8:1:2::/64", "2400:cb00::/64"}
+	for _, s := range bans {
+		tree.Insert(netip.MustParsePrefix(s), true)
+	}
+	for _, s := range bans {
+		assert.True(t, tree.Delete(netip.MustParsePrefix(s)))
+	}
+	assert.Equal(t, v4, countNodes(tree.v4))
+	assert.Equal(t, v6, countNodes(tree.v6))
+	assert.Equal(t, 2, tree.Len())
+	assert.True(t, tree.Delete(netip.MustParsePrefix("10.0.0.0/8")))
+	assert.Nil(t, tree.v4)
+
+	// 未插入的更长前缀不存在
+	assert.False(t, tree.Delete(netip.MustParsePrefix("2001:db8::/48")))
+	_, ok := tree.Lookup(netip.MustParseAddr("2001:db8::1"))
+	assert.True(t, ok)
+}
+
+func TestParsePrefix(t *testing.T) {
+	for s, want := range map[string]string{
+		"1.2.3.4":            "1.2.3.4/32",
+		"1.2.3.4/24":         "1.2.3.0/24",
+		"::ffff:1.2.3.4":     "1.2.3.4/32",
+		"2001:db8::1":        "2001:db8::1/128",
+		"2001:db8::1/64":     "2001:db8::/64",
+		"::ffff:1.2.3.0/120": "1.2.3.0/24",
+	} {
+		p, err := ParsePrefix(s)
+		assert.Nil(t, err)
+		assert.Equal(t, want, p.String(), s)
+	}
+	_, err := ParsePrefix("bad")
+	assert.NotNil(t, err)
+}
+
+func TestLoadSet(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "ips")
+	assert.Nil(t, os.WriteFile(file, []byte("# edge\n8.8.8.0/24\n\n2001:db8::/32\n"), 0o644))
+	s, err := LoadSet([]string{file})
+	assert.Nil(t, err)
+	assert.Equal(t, 2, s.Len())
+	assert.True(t, s.Contains(netip.MustParseAddr("8.8.8.8")))
+	assert.False(t, s.Contains(netip.MustParseAddr("8.8.9.8")))
+
+	_, err = LoadSet([]string{filepath.Join(t.TempDir(), "missing")})
+	assert.NotNil(t, err)
+	assert.Nil(t, os.WriteFile(file, []byte("bad\n"), 0o644))
+	_, err = LoadSet([]string{file})
+	assert.NotNil(t, err)
+}
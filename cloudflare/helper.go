@@ -2,8 +2,9 @@ package cloudflare
 
 import (
 	_ "embed"
-	"net"
-	"strings"
+	"net/netip"
+
+	"github.com/48Club/service_agent/cidr"
 )
 
 var (
@@ -12,27 +13,23 @@ var (
 	//go:embed ips-v6
 	_ipsV6 []byte
 
-	_ips []*net.IPNet
+	_ips *cidr.Set
 )
 
 func init() {
-	ips := strings.Split(string(_ipsV4), "\n")
-	ips = append(ips, strings.Split(string(_ipsV6), "\n")...)
-	for _, v := range ips {
-		_, ipNet, err := net.ParseCIDR(v)
-		if err != nil {
-			continue
-		}
-		_ips = append(_ips, ipNet)
+	var err error
+	if _ips, err = cidr.ParseSet(nil, _ipsV4, _ipsV6); err != nil {
+		panic(err)
 	}
 }
 
+// Embedded 编译时内置的 Cloudflare IP 段, 未配置 cidr_files 时使用
+func Embedded() *cidr.Set { return _ips }
+
 func IsCloudflareIP(ip string) bool {
-	ipAddr := net.ParseIP(ip)
-	for _, v := range _ips {
-		if v.Contains(ipAddr) {
-			return true
-		}
+	addr, err := netip.ParseAddr(ip)
+	if err != nil {
+		return false
 	}
-	return false
+	return _ips.Contains(addr)
 }
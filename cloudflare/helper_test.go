@@ -0,0 +1,14 @@
+package cloudflare
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestIsCloudflareIP(t *testing.T) {
+	assert.True(t, IsCloudflareIP("172.68.242.51"))
+	assert.True(t, IsCloudflareIP("2400:cb00::1"))
+	assert.False(t, IsCloudflareIP("8.8.8.8"))
+	assert.False(t, IsCloudflareIP("bad"))
+}
@@ -2,52 +2,928 @@ package config
 
 import (
 	"encoding/json"
+	"fmt"
+	"log"
+	"math/big"
+	"net/netip"
+	"net/url"
 	"os"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
 	"time"
 
+	"github.com/48Club/service_agent/acl"
+	"github.com/48Club/service_agent/broadcast"
+	"github.com/48Club/service_agent/bundle"
+	"github.com/48Club/service_agent/cidr"
+	"github.com/48Club/service_agent/cors"
+	"github.com/48Club/service_agent/edge"
+	"github.com/48Club/service_agent/gas"
 	"github.com/48Club/service_agent/limit"
+	"github.com/48Club/service_agent/logs"
+	"github.com/48Club/service_agent/rules"
+	"github.com/48Club/service_agent/server"
+	"github.com/48Club/service_agent/txcache"
+	"github.com/48Club/service_agent/upstream"
 	mapset "github.com/deckarep/golang-set/v2"
+	"github.com/ethereum/go-ethereum/common"
 )
 
 type Config struct {
-	Sentry                 string                       `json:"sentry"` // 哨兵节点
-	CDNPlatforms           string                       `json:"cdn_platforms"`
-	DomainsHelper          []string                     `json:"domains"` // 域名列表
-	Domains                mapset.Set[string]           `json:"-"`       // 域名列表, 用于快速查找
-	ExceptionLimiter       []exceptionLimiter           `json:"exception_limiter"`
-	ExceptionLimiterMap    map[string]*exceptionLimiter `json:"-"` // 异常限制器, 用于快速查找
-	SkipLimitMethodsHelper []string                     `json:"skip_limit_methods"`
-	SkipLimitMethods       mapset.Set[string]           `json:"-"` // 跳过限制的方法, 用于快速查找
-	MaxBatchQuery          int                          `json:"max_batch_query"`
+	Sentry                 string                               `json:"sentry"`       // 哨兵节点
+	CDNIPFiles             []string                             `json:"cdn_ip_files"` // 未配置 edges 时 cloudflare 的 IP 段文件, 为空使用内置列表
+	CDNPlatforms           string                               `json:"cdn_platforms"`
+	EdgesHelper            []edgeConfig                         `json:"edges"` // CDN / 负载均衡提供方, 为空时只有 cloudflare
+	TrustHelper            map[string]trustConfig               `json:"trust"` // 域名 => 信任配置, default 用于其他域名
+	Edges                  map[string]*edge.Edge                `json:"-"`
+	Trust                  map[string]*edge.Trust               `json:"-"`
+	CORSHelper             map[string]corsConfig                `json:"cors"` // 域名 => 跨域配置, default 用于其他域名
+	CORS                   map[string]*cors.Policy              `json:"-"`
+	RulesHelper            map[string][]ruleConfig              `json:"header_rules"` // 域名 => 请求头规则, default 用于其他域名
+	Rules                  map[string]rules.Set                 `json:"-"`
+	DomainsHelper          []string                             `json:"domains"` // 域名列表
+	Domains                mapset.Set[string]                   `json:"-"`       // 域名列表, 用于快速查找
+	ExceptionLimiter       []exceptionLimiter                   `json:"exception_limiter"`
+	ExceptionLimiterMap    map[string]*exceptionLimiter         `json:"-"` // 异常限制器, 用于快速查找
+	SkipLimitMethodsHelper []string                             `json:"skip_limit_methods"`
+	SkipLimitMethods       mapset.Set[string]                   `json:"-"`         // 跳过限制的方法, 用于快速查找
+	RawTx                  RawTx                                `json:"raw_tx"`    // eth_sendRawTransaction 校验
+	BroadcastHelper        broadcastConfig                      `json:"broadcast"` // 广播模式, 配置了节点时 eth_sendRawTransaction 由 agent 并发发送
+	Broadcaster            *broadcast.Broadcaster               `json:"-"`
+	PrivateTx              map[string]PrivateTx                 `json:"private_tx"` // 域名 => 隐私模式, default 用于其他域名, 交易只发送给私有中继
+	Bundle                 Bundle                               `json:"bundle"`
+	TxCache                TxCache                              `json:"tx_cache"`
+	SenderLimit            SenderLimit                          `json:"sender_limit"`
+	Receipts               Receipts                             `json:"receipts"`
+	GasPolicyHelper        map[string]gasPolicyConfig           `json:"gas_policy"` // 域名 => eth_gasPrice 等方法的返回值策略, default 用于其他域名, 未配置的域名直接转发
+	GasPolicy              map[string]*gas.Policy               `json:"-"`
+	LogsHelper             map[string]logsConfig                `json:"get_logs"` // 域名 => eth_getLogs 限制, default 用于其他域名
+	Logs                   map[string]*logs.Limits              `json:"-"`
+	PinBlockTagsHelper     []string                             `json:"pin_block_tags"` // 域名列表, default 表示所有域名, 将 latest, safe 和 finalized 改写为所有健康上游都已达到的区块号
+	PinBlockTags           mapset.Set[string]                   `json:"-"`
+	MaxBatchQuery          int                                  `json:"max_batch_query"`
+	AdminListen            string                               `json:"admin_listen"` // 管理端口, 默认只监听本地
+	ListenersHelper        []listenerConfig                     `json:"listeners"`    // RPC 监听地址, 默认只有 tcp :80
+	Listeners              []*server.Listener                   `json:"-"`
+	AdminToken             string                               `json:"admin_token"` // 管理 API 的 Bearer token, 为空则不开放管理 API
+	AccessLog              AccessLog                            `json:"access_log"`
+	Tracing                Tracing                              `json:"tracing"`
+	Upstreams              []Upstream                           `json:"upstreams"` // 上游节点列表, 为空时使用 sentry
+	Health                 Health                               `json:"health"`
+	UpstreamPool           *upstream.Pool                       `json:"-"`
+	AllowCIDRs             []string                             `json:"allow_cidrs"`       // 白名单, 如自有后端
+	DenyCIDRs              []string                             `json:"deny_cidrs"`        // 黑名单
+	AllowlistProfile       string                               `json:"allowlist_profile"` // 白名单使用的限速配置, 为空则不限速
+	LimitProfilesHelper    map[string][]limitRule               `json:"limit_profiles"`    // 命名的限速配置
+	LimitProfiles          map[string]limit.IPBasedRateLimiters `json:"-"`
+	Penalty                Penalty                              `json:"penalty"`
+	ProxyProtocol          ProxyProtocol                        `json:"proxy_protocol"`
+	TLS                    TLS                                  `json:"tls"`
+	allowPrefixes          []netip.Prefix
+	denyPrefixes           []netip.Prefix
+}
+
+// Penalty 反复触发限速后自动封禁
+type Penalty struct {
+	Threshold int             `json:"threshold"` // window 内超限次数, 0 表示不开启
+	Window    time.Duration   `json:"window"`    // 秒, 默认 60
+	Durations []time.Duration `json:"durations"` // 逐级递增的封禁时长, 秒, 默认 [60, 600, 3600]
+	Forget    time.Duration   `json:"forget"`    // 最后一次封禁后多久无违规等级清零, 秒, 默认 86400
+	Keys      bool            `json:"keys"`      // 是否同时封禁 API key
+}
+
+// ProxyProtocol 前置四层负载均衡时使用 PROXY protocol 传递客户端地址
+// 头中的地址同样按域名的 trust 配置检查, 客户端直连负载均衡时需要在 direct_cidrs 中放行
+type ProxyProtocol struct {
+	TrustedCIDRs []string      `json:"trusted_cidrs"` // 允许发送 PROXY 头的负载均衡 IP 段, 为空则不开启, 需要重启生效
+	Required     bool          `json:"required"`      // 受信任来源必须发送 PROXY 头
+	Timeout      time.Duration `json:"timeout"`       // 秒, 读取 PROXY 头的超时, 默认 10
+	Trusted      *cidr.Set     `json:"-"`
+}
+
+// TLS 直接对外提供 HTTPS, 不经过 CDN 时使用
+type TLS struct {
+	Listen       string             `json:"listen"`        // 如 :443, 为空则不开启, 需要重启生效
+	MinVersion   string             `json:"min_version"`   // 1.0, 1.1, 1.2, 1.3, 默认 1.2
+	CipherSuites []string           `json:"cipher_suites"` // TLS 1.2 及以下的加密套件, 为空使用 Go 默认值
+	Watch        time.Duration      `json:"watch"`         // 秒, 检查证书文件变化的间隔, 默认 60
+	Certs        map[string]tlsCert `json:"certs"`         // 域名 => 证书, 支持 *.example.com, default 用于其他域名
+	ClientKeys   map[string]string  `json:"client_keys"`   // 客户端证书 subject 或 CN => exception_limiter 的 domain, 可重载
+	HTTP3        bool               `json:"http3"`         // 在 listen 的 UDP 端口上提供 HTTP/3, 并通过 Alt-Svc 通告
+	Trust        string             `json:"trust"`         // local (默认, 对端即客户端) 或 cdn (按域名的 trust 配置), 对 HTTPS 和 HTTP/3 都生效
+	Profile      string             `json:"profile"`       // 该地址使用的限速配置, 为空按域名选择
+	Listener     *server.Listener   `json:"-"`
+}
+
+type broadcastConfig struct {
+	Endpoints   []broadcastEndpoint `json:"endpoints"`
+	MinAccepted int                 `json:"min_accepted"` // 至少多少个节点接受才返回成功, 默认 1
+}
+
+type broadcastEndpoint struct {
+	Name    string        `json:"name"`
+	URL     string        `json:"url"`
+	Timeout time.Duration `json:"timeout"` // 秒, 默认 5
+	Private bool          `json:"private"` // 私有中继, 隐私模式只发送给私有中继, 普通模式发送给全部节点
+}
+
+// TxCache 重复提交的 eth_sendRawTransaction 由 agent 返回上一次的结果
+// 开启后未配置 broadcast 时交易也由 agent 发送给上游, 以便得到结果
+type TxCache struct {
+	TTL               time.Duration `json:"ttl"`                // 秒, 0 表示不开启
+	MaxReplacements   int           `json:"max_replacements"`   // 同一 sender+nonce 在 replacement_window 内最多替换的次数, 0 表示不限制
+	ReplacementWindow time.Duration `json:"replacement_window"` // 秒, 默认 60
+}
+
+type gasPolicyConfig struct {
+	Mode       string  `json:"mode"`       // fixed, upstream 或 percentile
+	Value      uint64  `json:"value"`      // fixed 的值, wei
+	Multiplier float64 `json:"multiplier"` // upstream 的倍数, 默认 1
+	Min        uint64  `json:"min"`        // wei, 0 表示不限制
+	Max        uint64  `json:"max"`        // wei, 0 表示不限制
+	Percentile float64 `json:"percentile"` // percentile 的百分位, 0 到 100
+	Blocks     uint64  `json:"blocks"`     // percentile 统计的区块数, 默认 20
+}
+
+func (gc gasPolicyConfig) policy() (*gas.Policy, error) {
+	if gc.Multiplier == 0 {
+		gc.Multiplier = 1
+	}
+	if gc.Blocks == 0 {
+		gc.Blocks = 20
+	}
+	switch {
+	case gc.Mode != gas.Fixed && gc.Mode != gas.Upstream && gc.Mode != gas.Percentile:
+		return nil, fmt.Errorf("unknown mode %q", gc.Mode)
+	case gc.Multiplier < 0:
+		return nil, fmt.Errorf("bad multiplier %g", gc.Multiplier)
+	case gc.Percentile < 0 || gc.Percentile > 100:
+		return nil, fmt.Errorf("bad percentile %g", gc.Percentile)
+	case gc.Blocks > 1024:
+		return nil, fmt.Errorf("blocks %d exceeds 1024", gc.Blocks)
+	case gc.Max > 0 && gc.Min > gc.Max:
+		return nil, fmt.Errorf("min %d exceeds max %d", gc.Min, gc.Max)
+	}
+	return &gas.Policy{
+		Mode:       gc.Mode,
+		Value:      new(big.Int).SetUint64(gc.Value),
+		Multiplier: gc.Multiplier,
+		Min:        new(big.Int).SetUint64(gc.Min),
+		Max:        new(big.Int).SetUint64(gc.Max),
+		Percentile: gc.Percentile,
+		Blocks:     gc.Blocks,
+	}, nil
+}
+
+// GasPolicyFor 返回域名的 gas 策略, 为 nil 时转发给上游
+func (c *Config) GasPolicyFor(host string) *gas.Policy {
+	if p, ok := c.GasPolicy[host]; ok {
+		return p
+	}
+	return c.GasPolicy["default"]
+}
+
+type logsConfig struct {
+	MaxRange     uint64 `json:"max_range"`     // 最大区块范围, 0 表示不限制
+	MaxAddresses int    `json:"max_addresses"` // 最多 address 数, 0 表示不限制
+	MaxTopics    int    `json:"max_topics"`    // 所有位置的 topic 数之和, 0 表示不限制
+	ChunkSize    uint64 `json:"chunk_size"`    // 大于 0 时超过该区块数的查询由 agent 拆分执行, 需要配置 max_range, 结果大小受 max_response_body_size 限制
+	Concurrency  int    `json:"concurrency"`   // 拆分后的并发数, 默认 4
+}
+
+// LogsFor 返回域名的 eth_getLogs 限制, 为 nil 时不限制
+func (c *Config) LogsFor(host string) *logs.Limits {
+	if l, ok := c.Logs[host]; ok {
+		return l
+	}
+	return c.Logs["default"]
+}
+
+// PinBlockTagsFor 域名是否改写区块标签
+func (c *Config) PinBlockTagsFor(host string) bool {
+	return c.PinBlockTags.ContainsOne(host) || c.PinBlockTags.ContainsOne("default")
+}
+
+// Receipts 跟踪经 agent 提交的交易, 通过 eth_getTransactionSubmission 查询, 上链后向 API key 的 webhook 回调
+// 开启后未配置 broadcast 时交易也由 agent 发送给上游, 只记录节点接受的交易
+type Receipts struct {
+	DB        string        `json:"db"`         // sqlite 文件路径, 为空则不开启, 需要重启生效
+	Interval  time.Duration `json:"interval"`   // 秒, 轮询间隔, 默认 3
+	DropAfter time.Duration `json:"drop_after"` // 秒, 不在交易池中且超过该时长未上链视为丢弃, 默认 600
+	Retention time.Duration `json:"retention"`  // 秒, 记录保留时长, 默认 604800
+}
+
+// SenderLimit 按交易发送方限速, 同一发送方使用多个 IP 时仍然有效
+type SenderLimit struct {
+	LimitsHelper []limitRule                `json:"limits"`        // 为空则不限速
+	MaxNonceGap  uint64                     `json:"max_nonce_gap"` // 交易 nonce 最多超出发送方 pending nonce 多少, 0 表示不检查
+	AllowHelper  []string                   `json:"allow"`         // 不受限制的发送方地址
+	Limits       limit.IPBasedRateLimiters  `json:"-"`
+	Allow        mapset.Set[common.Address] `json:"-"`
+}
+
+// Bundle eth_sendBundle, eth_callBundle 和 eth_cancelBundle 由 agent 校验后转发给 builder, 不计入普通请求的限速
+type Bundle struct {
+	BuildersHelper []builderConfig                      `json:"builders"` // 为空则不开启, bundle 方法按普通请求转发给上游
+	MaxTxs         int                                  `json:"max_txs"`  // 单个 bundle 的最大交易数, 默认 50
+	LimitsHelper   map[string][]limitRule               `json:"limits"`   // API key => 限速, default 用于其他 key 且配置 builder 时必填, 没有 key 时按 IP 计数
+	Builders       bundle.Builders                      `json:"-"`
+	Limits         map[string]limit.IPBasedRateLimiters `json:"-"`
+}
+
+// LimitsFor 返回 API key 的 bundle 限速, 为 nil 表示不限速
+func (b Bundle) LimitsFor(key string) limit.IPBasedRateLimiters {
+	if l, ok := b.Limits[key]; ok && key != "" {
+		return l
+	}
+	return b.Limits["default"]
+}
+
+type builderConfig struct {
+	Name    string        `json:"name"`
+	URL     string        `json:"url"`
+	Timeout time.Duration `json:"timeout"` // 秒, 默认 5
+}
+
+// PrivateTx 隐私模式, fallback_blocks 个区块内未上链时回退为公开广播
+type PrivateTx struct {
+	FallbackBlocks uint64 `json:"fallback_blocks"` // 0 表示不回退
+}
+
+// PrivateTxFor 返回域名的隐私模式配置
+func (c *Config) PrivateTxFor(host string) (PrivateTx, bool) {
+	if p, ok := c.PrivateTx[host]; ok {
+		return p, true
+	}
+	p, ok := c.PrivateTx["default"]
+	return p, ok
+}
+
+// RawTx eth_sendRawTransaction 在转发前的校验规则
+type RawTx struct {
+	ChainID          uint64            `json:"chain_id"`      // 为 0 时使用上游节点的 chain id
+	MaxDataSize      int               `json:"max_data_size"` // 交易 data 的最大字节数, 默认 131072
+	TxTypesHelper    []int             `json:"tx_types"`      // 允许的交易类型, 默认 0 到 4
+	TxTypes          mapset.Set[uint8] `json:"-"`
+	MinGasPrice      map[string]uint64 `json:"min_gas_price"`     // 域名 => 最低 gas price (wei), default 用于其他域名
+	AllowUnprotected bool              `json:"allow_unprotected"` // 允许没有 chain id 的 legacy 交易
+}
+
+// MinGasPriceFor 返回域名的最低 gas price
+func (r RawTx) MinGasPriceFor(host string) uint64 {
+	if p, ok := r.MinGasPrice[host]; ok {
+		return p
+	}
+	return r.MinGasPrice["default"]
+}
+
+type listenerConfig struct {
+	Network string `json:"network"` // tcp (默认), unix 或 systemd
+	Address string `json:"address"` // tcp 为 host:port, unix 为文件路径, systemd 为 FileDescriptorName, 为空使用全部 fd
+	Mode    string `json:"mode"`    // unix socket 文件权限, 如 0660
+	H2C     bool   `json:"h2c"`     // 同时接受明文 HTTP/2 (prior knowledge), WebSocket 仍需 HTTP/1.1
+	Trust   string `json:"trust"`   // cdn (默认, 按域名的 trust 配置) 或 local (对端即客户端, 用于本机服务)
+	Profile string `json:"profile"` // 该地址使用的限速配置, 为空按域名选择
+}
+
+type tlsCert struct {
+	Cert      string   `json:"cert"`
+	Key       string   `json:"key"`
+	ClientCAs []string `json:"client_cas"` // 配置后该域名要求客户端证书 (mTLS)
+}
+
+// Sites 转换为 server.CertStore 的证书配置
+func (t TLS) Sites() map[string]server.Site {
+	sites := map[string]server.Site{}
+	for name, c := range t.Certs {
+		sites[name] = server.Site{Cert: c.Cert, Key: c.Key, ClientCAs: c.ClientCAs}
+	}
+	return sites
+}
+
+// RequireClientCert 域名是否要求客户端证书, 匹配规则与 SNI 相同
+func (t TLS) RequireClientCert(host string) bool {
+	host = strings.ToLower(host)
+	c, ok := t.Certs[host]
+	if i := strings.IndexByte(host, '.'); !ok && i > 0 {
+		c, ok = t.Certs["*"+host[i:]]
+	}
+	if !ok {
+		c = t.Certs["default"]
+	}
+	return len(c.ClientCAs) > 0
+}
+
+type edgeConfig struct {
+	Name           string   `json:"name"`
+	CIDRFiles      []string `json:"cidr_files"`
+	ClientIPHeader string   `json:"client_ip_header"` // CF-Connecting-IP, Fastly-Client-IP, True-Client-IP, X-Forwarded-For
+	XFFHops        int      `json:"xff_hops"`         // X-Forwarded-For 从右往左第几个是客户端 IP, 默认 1
+}
+
+type trustConfig struct {
+	Edges       []string `json:"edges"`        // 信任的 edge 名称
+	DirectCIDRs []string `json:"direct_cidrs"` // 允许直连的 IP 段
+}
+
+type limitRule struct {
+	Window time.Duration `json:"window"` // 秒
+	Limit  int           `json:"limit"`
+}
+
+type Upstream struct {
+	Name string `json:"name"`
+	URL  string `json:"url"`
+	WS   string `json:"ws"` // websocket 地址, 为空时由 url 推导
+}
+
+type Health struct {
+	Interval time.Duration          `json:"interval"` // 轮询间隔, 秒
+	Chains   map[string]chainHealth `json:"chains"`   // chainId => 健康阈值
+}
+
+type chainHealth struct {
+	MaxHeadAge time.Duration `json:"max_head_age"` // 秒, 默认 20
+	MinPeers   uint64        `json:"min_peers"`
+}
+
+type Tracing struct {
+	Exporter    string  `json:"exporter"`     // otlp 或 stdout, 为空则不开启
+	Endpoint    string  `json:"endpoint"`     // OTLP/HTTP 地址, 如 localhost:4318
+	Insecure    bool    `json:"insecure"`     // OTLP 不使用 TLS
+	SampleRatio float64 `json:"sample_ratio"` // 采样率 (0, 1], 默认 1
+}
+
+type AccessLog struct {
+	Output            string   `json:"output"`              // stdout 或日志文件路径, 默认 stdout
+	MaxSize           int      `json:"max_size"`            // 单个日志文件大小上限, MB
+	MaxBackups        int      `json:"max_backups"`         // 保留的旧日志文件数量
+	MaxAge            int      `json:"max_age"`             // 旧日志文件保留天数
+	SuccessSampleRate *float64 `json:"success_sample_rate"` // 成功请求的采样率 [0, 1], 默认 1, 错误请求全部记录
 }
 
 type exceptionLimiter struct {
-	Domain string                    `json:"domain"`
-	Window time.Duration             `json:"window"`
-	Limit  int                       `json:"limit"`
-	XToken string                    `json:"x-48-token"`
-	Limter limit.IPBasedRateLimiters `json:"-"`
+	Domain        string                    `json:"domain"`
+	Window        time.Duration             `json:"window"`
+	Limit         int                       `json:"limit"`
+	XToken        string                    `json:"x-48-token"`
+	Webhook       string                    `json:"webhook"`        // 该 key 提交的交易上链后回调的地址, 需要开启 receipts
+	WebhookSecret string                    `json:"webhook_secret"` // webhook 的 HMAC-SHA256 签名密钥
+	CORSHelper    *corsConfig               `json:"cors"`           // 该 key 的跨域配置, 优先于同名域名的配置
+	CORS          *cors.Policy              `json:"-"`
+	Limter        limit.IPBasedRateLimiters `json:"-"`
+}
+
+type ruleConfig struct {
+	Name    string `json:"name"`    // 用于日志与监控, 默认为 header:序号
+	Header  string `json:"header"`  // Origin, Referer, User-Agent 等
+	Match   string `json:"match"`   // 通配符模式, 如 *HeadlessChrome*
+	Missing bool   `json:"missing"` // 匹配请求头缺失
+	Action  string `json:"action"`  // allow, deny 或 profile
+	Profile string `json:"profile"` // action 为 profile 时使用的限速配置
+}
+
+type corsConfig struct {
+	Origins     []string      `json:"origins"`     // 为空允许所有来源, 支持 https://*.example.com 匹配子域名
+	Methods     []string      `json:"methods"`     // 为空使用默认值
+	Headers     []string      `json:"headers"`     // 为空使用默认值
+	Credentials bool          `json:"credentials"` // 允许携带凭据, 此时 origins 不能包含 *
+	MaxAge      time.Duration `json:"max_age"`     // 秒, 预检结果的缓存时间
 }
 
-var GlobalConfig = Config{}
+func (c corsConfig) policy(name string) (*cors.Policy, error) {
+	if c.Credentials && (len(c.Origins) == 0 || slices.Contains(c.Origins, "*")) {
+		return nil, fmt.Errorf("cors %s: credentials require explicit origins", name)
+	}
+	return cors.New(c.Origins, c.Methods, c.Headers, c.Credentials, c.MaxAge*time.Second), nil
+}
+
+// global 通过 Reload 整体替换, 使用方通过 Get 读取, 不要长期持有其中的字段
+var (
+	global   atomic.Pointer[Config]
+	reloadMu sync.Mutex
+)
+
+// Get 返回当前生效的配置
+func Get() *Config {
+	return global.Load()
+}
 
 func init() {
-	file, err := os.ReadFile("config.json")
+	cfg, err := load()
 	if err != nil {
 		panic(err)
 	}
 
-	err = json.Unmarshal(file, &GlobalConfig)
+	if len(cfg.Upstreams) == 0 {
+		cfg.Upstreams = []Upstream{{Name: "sentry", URL: cfg.Sentry}}
+	}
+	upstreams := []*upstream.Upstream{}
+	for _, u := range cfg.Upstreams {
+		if u.WS == "" {
+			u.WS = fmt.Sprintf("ws://%s", strings.Split(u.URL, "://")[1])
+		}
+		upstreams = append(upstreams, upstream.New(u.Name, u.URL, u.WS))
+	}
+	thresholds := map[uint64]upstream.Thresholds{}
+	for chainId, th := range cfg.Health.Chains {
+		id, err := strconv.ParseUint(chainId, 10, 64)
+		if err != nil {
+			panic(err)
+		}
+		if th.MaxHeadAge == 0 {
+			th.MaxHeadAge = upstream.DefaultThresholds.MaxHeadAge / time.Second
+		}
+		thresholds[id] = upstream.Thresholds{MaxHeadAge: th.MaxHeadAge * time.Second, MinPeers: th.MinPeers}
+	}
+	cfg.UpstreamPool = upstream.NewPool(upstreams, thresholds, cfg.Health.Interval*time.Second)
+
+	global.Store(cfg)
+	cfg.apply()
+}
+
+// apply 将配置同步到运行时的各个模块
+func (cfg *Config) apply() {
+	acl.Allow.SetStatic(cfg.allowPrefixes)
+	acl.Deny.SetStatic(cfg.denyPrefixes)
+
+	durations := []time.Duration{}
+	for _, d := range cfg.Penalty.Durations {
+		durations = append(durations, d*time.Second)
+	}
+	acl.Penalties.Configure(cfg.Penalty.Threshold, cfg.Penalty.Window*time.Second, durations, cfg.Penalty.Forget*time.Second)
+	txcache.Txs.Configure(cfg.TxCache.TTL*time.Second, cfg.TxCache.MaxReplacements, cfg.TxCache.ReplacementWindow*time.Second)
+}
+
+func load() (*Config, error) {
+	file, err := os.ReadFile("config.json")
 	if err != nil {
-		panic(err)
+		return nil, err
+	}
+
+	cfg := &Config{}
+	err = json.Unmarshal(file, cfg)
+	if err != nil {
+		return nil, err
 	}
 
-	GlobalConfig.ExceptionLimiterMap = map[string]*exceptionLimiter{}
-	for _, exception := range GlobalConfig.ExceptionLimiter {
+	cfg.ExceptionLimiterMap = map[string]*exceptionLimiter{}
+	for _, exception := range cfg.ExceptionLimiter {
 		exception.Limter = limit.IPBasedRateLimiters{limit.NewIPBasedRateLimiter(exception.Limit, exception.Window*time.Second)}
-		GlobalConfig.ExceptionLimiterMap[exception.Domain] = &exception
+		if exception.CORSHelper != nil {
+			if exception.CORS, err = exception.CORSHelper.policy(exception.Domain); err != nil {
+				return nil, err
+			}
+		}
+		cfg.ExceptionLimiterMap[exception.Domain] = &exception
 	}
 
-	GlobalConfig.Domains = mapset.NewSet(GlobalConfig.DomainsHelper...)
-	GlobalConfig.SkipLimitMethods = mapset.NewSet(GlobalConfig.SkipLimitMethodsHelper...)
+	cfg.LimitProfiles = map[string]limit.IPBasedRateLimiters{}
+	for name, rules := range cfg.LimitProfilesHelper {
+		limiters := limit.IPBasedRateLimiters{}
+		for _, rule := range rules {
+			limiters = append(limiters, limit.NewIPBasedRateLimiter(rule.Limit, rule.Window*time.Second))
+		}
+		cfg.LimitProfiles[name] = limiters
+	}
+	if cfg.AllowlistProfile != "" {
+		if _, ok := cfg.LimitProfiles[cfg.AllowlistProfile]; !ok {
+			return nil, fmt.Errorf("unknown allowlist_profile: %s", cfg.AllowlistProfile)
+		}
+	}
+
+	cfg.CORS = map[string]*cors.Policy{"default": cors.New(nil, nil, nil, false, 0)}
+	for domain, c := range cfg.CORSHelper {
+		if cfg.CORS[domain], err = c.policy(domain); err != nil {
+			return nil, err
+		}
+	}
+
+	cfg.Rules = map[string]rules.Set{}
+	for domain, list := range cfg.RulesHelper {
+		set := rules.Set{}
+		for i, rc := range list {
+			if rc.Name == "" {
+				rc.Name = fmt.Sprintf("%s:%d", strings.ToLower(rc.Header), i)
+			}
+			if _, ok := cfg.LimitProfiles[rc.Profile]; rc.Action == rules.Profile && rc.Profile != "unlimited" && !ok {
+				return nil, fmt.Errorf("rule %s: unknown profile %s", rc.Name, rc.Profile)
+			}
+			r, err := rules.New(rc.Name, rc.Header, rc.Match, rc.Missing, rc.Action, rc.Profile)
+			if err != nil {
+				return nil, err
+			}
+			set = append(set, r)
+		}
+		cfg.Rules[domain] = set
+	}
+
+	if err := cfg.loadTrust(); err != nil {
+		return nil, err
+	}
+
+	for _, s := range cfg.AllowCIDRs {
+		p, err := cidr.ParsePrefix(s)
+		if err != nil {
+			return nil, err
+		}
+		cfg.allowPrefixes = append(cfg.allowPrefixes, p)
+	}
+	for _, s := range cfg.DenyCIDRs {
+		p, err := cidr.ParsePrefix(s)
+		if err != nil {
+			return nil, err
+		}
+		cfg.denyPrefixes = append(cfg.denyPrefixes, p)
+	}
+
+	if len(cfg.ProxyProtocol.TrustedCIDRs) > 0 {
+		prefixes := []netip.Prefix{}
+		for _, s := range cfg.ProxyProtocol.TrustedCIDRs {
+			p, err := cidr.ParsePrefix(s)
+			if err != nil {
+				return nil, err
+			}
+			prefixes = append(prefixes, p)
+		}
+		cfg.ProxyProtocol.Trusted = cidr.SetOf(prefixes)
+	}
+	if cfg.ProxyProtocol.Timeout == 0 {
+		cfg.ProxyProtocol.Timeout = 10
+	}
+
+	certs := map[string]tlsCert{}
+	for name, c := range cfg.TLS.Certs {
+		certs[strings.ToLower(name)] = c
+	}
+	cfg.TLS.Certs = certs
+	for subject, key := range cfg.TLS.ClientKeys {
+		if _, ok := cfg.ExceptionLimiterMap[key]; !ok {
+			return nil, fmt.Errorf("tls client key %s: unknown exception_limiter domain %s", subject, key)
+		}
+	}
+	if err := cfg.loadListeners(); err != nil {
+		return nil, err
+	}
+
+	if cfg.RawTx.MaxDataSize == 0 {
+		cfg.RawTx.MaxDataSize = 128 << 10
+	}
+	if len(cfg.RawTx.TxTypesHelper) == 0 {
+		cfg.RawTx.TxTypesHelper = []int{0, 1, 2, 3, 4}
+	}
+	cfg.RawTx.TxTypes = mapset.NewSet[uint8]()
+	for _, t := range cfg.RawTx.TxTypesHelper {
+		if t < 0 || t > 0x7f {
+			return nil, fmt.Errorf("raw_tx: bad tx type %d", t)
+		}
+		cfg.RawTx.TxTypes.Add(uint8(t))
+	}
+
+	if bc := cfg.BroadcastHelper; len(bc.Endpoints) > 0 {
+		if bc.MinAccepted > len(bc.Endpoints) {
+			return nil, fmt.Errorf("broadcast: min_accepted %d exceeds %d endpoints", bc.MinAccepted, len(bc.Endpoints))
+		}
+		cfg.Broadcaster = &broadcast.Broadcaster{MinAccepted: bc.MinAccepted}
+		for _, e := range bc.Endpoints {
+			ep, err := broadcast.NewEndpoint(e.Name, e.URL, e.Timeout*time.Second, e.Private)
+			if err != nil {
+				return nil, err
+			}
+			cfg.Broadcaster.Endpoints = append(cfg.Broadcaster.Endpoints, ep)
+		}
+	}
+	// 0.48.club 原先固定返回 1 wei
+	if _, ok := cfg.GasPolicyHelper["0.48.club"]; !ok {
+		if cfg.GasPolicyHelper == nil {
+			cfg.GasPolicyHelper = map[string]gasPolicyConfig{}
+		}
+		cfg.GasPolicyHelper["0.48.club"] = gasPolicyConfig{Mode: gas.Fixed, Value: 1}
+	}
+	cfg.GasPolicy = map[string]*gas.Policy{}
+	for domain, gc := range cfg.GasPolicyHelper {
+		if cfg.GasPolicy[domain], err = gc.policy(); err != nil {
+			return nil, fmt.Errorf("gas_policy %s: %w", domain, err)
+		}
+	}
+
+	cfg.Logs = map[string]*logs.Limits{}
+	for domain, lc := range cfg.LogsHelper {
+		if lc.Concurrency <= 0 {
+			lc.Concurrency = 4
+		}
+		switch {
+		case lc.ChunkSize > 0 && lc.MaxRange == 0:
+			return nil, fmt.Errorf("get_logs %s: chunk_size requires max_range", domain)
+		case lc.ChunkSize > lc.MaxRange:
+			return nil, fmt.Errorf("get_logs %s: chunk_size %d exceeds max_range %d", domain, lc.ChunkSize, lc.MaxRange)
+		case lc.ChunkSize > 0 && (lc.MaxRange-1)/lc.ChunkSize+1 > logs.MaxChunks:
+			return nil, fmt.Errorf("get_logs %s: max_range %d needs more than %d chunks of %d", domain, lc.MaxRange, logs.MaxChunks, lc.ChunkSize)
+		}
+		cfg.Logs[domain] = &logs.Limits{
+			MaxRange:     lc.MaxRange,
+			MaxAddresses: lc.MaxAddresses,
+			MaxTopics:    lc.MaxTopics,
+			ChunkSize:    lc.ChunkSize,
+			Concurrency:  lc.Concurrency,
+		}
+	}
+
+	if cfg.AccessLog.SuccessSampleRate == nil {
+		rate := 1.0
+		cfg.AccessLog.SuccessSampleRate = &rate
+	}
+
+	if cfg.Receipts.Interval == 0 {
+		cfg.Receipts.Interval = 3
+	}
+	if cfg.Receipts.DropAfter == 0 {
+		cfg.Receipts.DropAfter = 600
+	}
+	if cfg.Receipts.Retention == 0 {
+		cfg.Receipts.Retention = 7 * 86400
+	}
+
+	if cfg.TxCache.ReplacementWindow == 0 {
+		cfg.TxCache.ReplacementWindow = 60
+	}
+
+	for _, rule := range cfg.SenderLimit.LimitsHelper {
+		cfg.SenderLimit.Limits = append(cfg.SenderLimit.Limits, limit.NewIPBasedRateLimiter(rule.Limit, rule.Window*time.Second))
+	}
+	cfg.SenderLimit.Allow = mapset.NewSet[common.Address]()
+	for _, addr := range cfg.SenderLimit.AllowHelper {
+		if !common.IsHexAddress(addr) {
+			return nil, fmt.Errorf("sender_limit: bad address %s", addr)
+		}
+		cfg.SenderLimit.Allow.Add(common.HexToAddress(addr))
+	}
+
+	if cfg.Bundle.MaxTxs == 0 {
+		cfg.Bundle.MaxTxs = 50
+	}
+	for _, b := range cfg.Bundle.BuildersHelper {
+		builder, err := bundle.NewBuilder(b.Name, b.URL, b.Timeout*time.Second)
+		if err != nil {
+			return nil, err
+		}
+		cfg.Bundle.Builders = append(cfg.Bundle.Builders, builder)
+	}
+	cfg.Bundle.Limits = map[string]limit.IPBasedRateLimiters{}
+	for key, rules := range cfg.Bundle.LimitsHelper {
+		limiters := limit.IPBasedRateLimiters{}
+		for _, rule := range rules {
+			limiters = append(limiters, limit.NewIPBasedRateLimiter(rule.Limit, rule.Window*time.Second))
+		}
+		cfg.Bundle.Limits[key] = limiters
+	}
+	// bundle 不计入普通请求的限速且会发送给所有 builder, 必须有兜底限速
+	if len(cfg.Bundle.Builders) > 0 && len(cfg.Bundle.Limits["default"]) == 0 {
+		return nil, fmt.Errorf("bundle: builders configured without a default limit")
+	}
+
+	if len(cfg.PrivateTx) > 0 && (cfg.Broadcaster == nil || len(cfg.Broadcaster.Private()) == 0) {
+		return nil, fmt.Errorf("private_tx: no private broadcast endpoints")
+	}
+
+	if cfg.TLS.Watch == 0 {
+		cfg.TLS.Watch = 60
+	}
+
+	if cfg.Penalty.Window == 0 {
+		cfg.Penalty.Window = 60
+	}
+	if len(cfg.Penalty.Durations) == 0 {
+		cfg.Penalty.Durations = []time.Duration{60, 600, 3600}
+	}
+	if cfg.Penalty.Forget == 0 {
+		cfg.Penalty.Forget = 86400
+	}
+
+	if cfg.AdminListen == "" {
+		cfg.AdminListen = "127.0.0.1:9048"
+	}
+
+	cfg.Domains = mapset.NewSet(cfg.DomainsHelper...)
+	cfg.SkipLimitMethods = mapset.NewSet(cfg.SkipLimitMethodsHelper...)
+	cfg.PinBlockTags = mapset.NewSet(cfg.PinBlockTagsHelper...)
+	return cfg, nil
+}
+
+func (cfg *Config) loadTrust() error {
+	if len(cfg.EdgesHelper) == 0 {
+		cfg.EdgesHelper = []edgeConfig{{Name: "cloudflare", CIDRFiles: cfg.CDNIPFiles, ClientIPHeader: "CF-Connecting-IP"}}
+	}
+	cfg.Edges = map[string]*edge.Edge{}
+	for _, e := range cfg.EdgesHelper {
+		ed, err := edge.New(e.Name, e.ClientIPHeader, e.XFFHops, e.CIDRFiles)
+		if err != nil {
+			return err
+		}
+		cfg.Edges[e.Name] = ed
+	}
+
+	if _, ok := cfg.TrustHelper["default"]; !ok {
+		if cfg.TrustHelper == nil {
+			cfg.TrustHelper = map[string]trustConfig{}
+		}
+		cfg.TrustHelper["default"] = trustConfig{Edges: []string{cfg.EdgesHelper[0].Name}}
+	}
+	cfg.Trust = map[string]*edge.Trust{}
+	for domain, t := range cfg.TrustHelper {
+		trust := &edge.Trust{}
+		for _, name := range t.Edges {
+			e, ok := cfg.Edges[name]
+			if !ok {
+				return fmt.Errorf("trust %s: unknown edge %s", domain, name)
+			}
+			trust.Edges = append(trust.Edges, e)
+		}
+		if len(t.DirectCIDRs) > 0 {
+			prefixes := []netip.Prefix{}
+			for _, s := range t.DirectCIDRs {
+				p, err := cidr.ParsePrefix(s)
+				if err != nil {
+					return err
+				}
+				prefixes = append(prefixes, p)
+			}
+			trust.Direct = cidr.SetOf(prefixes)
+		}
+		cfg.Trust[domain] = trust
+	}
+	return nil
+}
+
+func (cfg *Config) loadListeners() error {
+	if len(cfg.ListenersHelper) == 0 {
+		cfg.ListenersHelper = []listenerConfig{{Network: "tcp", Address: ":80"}}
+	}
+	for _, l := range cfg.ListenersHelper {
+		listener := &server.Listener{Network: l.Network, Address: l.Address, H2C: l.H2C, Profile: l.Profile}
+		switch l.Trust {
+		case "", "cdn":
+		case "local":
+			listener.Local = true
+		default:
+			return fmt.Errorf("listener %s: unknown trust %s", listener, l.Trust)
+		}
+		if l.Mode != "" {
+			mode, err := strconv.ParseUint(l.Mode, 8, 32)
+			if err != nil {
+				return fmt.Errorf("listener %s: bad mode %s", listener, l.Mode)
+			}
+			listener.Mode = os.FileMode(mode)
+		}
+		if _, ok := cfg.LimitProfiles[l.Profile]; l.Profile != "" && l.Profile != "unlimited" && !ok {
+			return fmt.Errorf("listener %s: unknown profile %s", listener, l.Profile)
+		}
+		cfg.Listeners = append(cfg.Listeners, listener)
+	}
+
+	if tc := cfg.TLS; tc.Listen != "" {
+		listener := &server.Listener{Network: "tcp", Address: tc.Listen, Profile: tc.Profile}
+		switch tc.Trust {
+		case "", "local":
+			listener.Local = true
+		case "cdn":
+		default:
+			return fmt.Errorf("tls: unknown trust %s", tc.Trust)
+		}
+		if _, ok := cfg.LimitProfiles[tc.Profile]; tc.Profile != "" && tc.Profile != "unlimited" && !ok {
+			return fmt.Errorf("tls: unknown profile %s", tc.Profile)
+		}
+		cfg.TLS.Listener = listener
+	}
+	return nil
+}
+
+// CORSFor 返回请求的跨域配置, 依次为 API key, 域名, default, key 为空表示未携带 API key
+func (cfg *Config) CORSFor(key, host string) *cors.Policy {
+	if exception, ok := cfg.ExceptionLimiterMap[key]; ok && exception.CORS != nil {
+		return exception.CORS
+	}
+	if p, ok := cfg.CORS[host]; ok {
+		return p
+	}
+	return cfg.CORS["default"]
+}
+
+// RulesFor 返回域名的请求头规则, 未配置时使用 default
+func (cfg *Config) RulesFor(host string) rules.Set {
+	if s, ok := cfg.Rules[host]; ok {
+		return s
+	}
+	return cfg.Rules["default"]
+}
+
+// TrustFor 返回域名的信任配置
+func (cfg *Config) TrustFor(host string) *edge.Trust {
+	if t, ok := cfg.Trust[host]; ok {
+		return t
+	}
+	return cfg.Trust["default"]
+}
+
+// Reload 重新读取 config.json
+// 上游列表, 健康检查, 监听地址, 日志与 tracing 配置需要重启才能生效
+func Reload() error {
+	reloadMu.Lock()
+	defer reloadMu.Unlock()
+	cfg, err := load()
+	if err != nil {
+		return err
+	}
+
+	old := Get()
+	cfg.Upstreams, cfg.Health, cfg.UpstreamPool = old.Upstreams, old.Health, old.UpstreamPool
+	cfg.Receipts = old.Receipts
+	cfg.AdminListen, cfg.AccessLog, cfg.Tracing = old.AdminListen, old.AccessLog, old.Tracing
+	cfg.ListenersHelper, cfg.Listeners = old.ListenersHelper, old.Listeners
+	// 证书文件的变化由 CertStore 自行检测, 只有 client_keys 随配置重载
+	clientKeys := cfg.TLS.ClientKeys
+	cfg.TLS = old.TLS
+	cfg.TLS.ClientKeys = clientKeys
+
+	// 限额未变化的限速器保留当前计数
+	for domain, exception := range cfg.ExceptionLimiterMap {
+		if prev, ok := old.ExceptionLimiterMap[domain]; ok && prev.Limit == exception.Limit && prev.Window == exception.Window {
+			exception.Limter = prev.Limter
+		}
+	}
+	for name, rules := range cfg.LimitProfilesHelper {
+		if slices.Equal(rules, old.LimitProfilesHelper[name]) {
+			cfg.LimitProfiles[name] = old.LimitProfiles[name]
+		}
+	}
+	if slices.Equal(cfg.SenderLimit.LimitsHelper, old.SenderLimit.LimitsHelper) {
+		cfg.SenderLimit.Limits = old.SenderLimit.Limits
+	}
+	for key, rules := range cfg.Bundle.LimitsHelper {
+		if slices.Equal(rules, old.Bundle.LimitsHelper[key]) {
+			cfg.Bundle.Limits[key] = old.Bundle.Limits[key]
+		}
+	}
+
+	global.Store(cfg)
+	cfg.apply()
+	log.Print("config reloaded")
+	return nil
+}
+
+// Redacted 返回隐藏了密钥的配置副本, 用于展示
+func (c Config) Redacted() Config {
+	c.AdminToken = redact(c.AdminToken)
+	c.ExceptionLimiter = append([]exceptionLimiter{}, c.ExceptionLimiter...)
+	for i := range c.ExceptionLimiter {
+		c.ExceptionLimiter[i].XToken = redact(c.ExceptionLimiter[i].XToken)
+		c.ExceptionLimiter[i].WebhookSecret = redact(c.ExceptionLimiter[i].WebhookSecret)
+		c.ExceptionLimiter[i].Webhook = redactURL(c.ExceptionLimiter[i].Webhook)
+	}
+	// 节点地址中常带有服务商的 API key
+	c.Sentry = redactURL(c.Sentry)
+	c.Upstreams = append([]Upstream{}, c.Upstreams...)
+	for i := range c.Upstreams {
+		c.Upstreams[i].URL = redactURL(c.Upstreams[i].URL)
+		c.Upstreams[i].WS = redactURL(c.Upstreams[i].WS)
+	}
+	c.BroadcastHelper.Endpoints = append([]broadcastEndpoint{}, c.BroadcastHelper.Endpoints...)
+	for i := range c.BroadcastHelper.Endpoints {
+		c.BroadcastHelper.Endpoints[i].URL = redactURL(c.BroadcastHelper.Endpoints[i].URL)
+	}
+	c.Bundle.BuildersHelper = append([]builderConfig{}, c.Bundle.BuildersHelper...)
+	for i := range c.Bundle.BuildersHelper {
+		c.Bundle.BuildersHelper[i].URL = redactURL(c.Bundle.BuildersHelper[i].URL)
+	}
+	return c
+}
+
+// redactURL 只保留 scheme 和 host, 隐藏 userinfo, 路径和查询参数
+func redactURL(s string) string {
+	u, err := url.Parse(s)
+	if err != nil || u.Host == "" {
+		return redact(s)
+	}
+	r := u.Scheme + "://"
+	if u.User != nil {
+		r += "***@"
+	}
+	r += u.Host
+	if u.Path != "" && u.Path != "/" {
+		r += "/***"
+	}
+	if u.RawQuery != "" {
+		r += "?***"
+	}
+	return r
+}
+
+func redact(s string) string {
+	if s == "" {
+		return ""
+	}
+	return "***"
 }
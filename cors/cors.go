@@ -0,0 +1,101 @@
+package cors
+
+import (
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var (
+	DefaultMethods = []string{"GET", "POST", "PUT", "DELETE", "OPTIONS"}
+	DefaultHeaders = []string{"Accept", "Authorization", "Cache-Control", "Content-Type", "DNT", "If-Modified-Since", "Keep-Alive", "Origin", "User-Agent", "X-Requested-With"}
+)
+
+// Policy 一个域名或 API key 的跨域配置
+type Policy struct {
+	origins     []string // *, https://example.com 或 https://*.example.com
+	methods     []string
+	headers     []string // 小写, 用于匹配 Access-Control-Request-Headers
+	credentials bool
+	maxAge      time.Duration
+
+	allowMethods string
+	allowHeaders string
+}
+
+// New origins 为空表示允许所有来源, methods 和 headers 为空使用默认值
+func New(origins, methods, headers []string, credentials bool, maxAge time.Duration) *Policy {
+	if len(origins) == 0 {
+		origins = []string{"*"}
+	}
+	if len(methods) == 0 {
+		methods = DefaultMethods
+	}
+	if len(headers) == 0 {
+		headers = DefaultHeaders
+	}
+	p := &Policy{credentials: credentials, maxAge: maxAge}
+	for _, o := range origins {
+		p.origins = append(p.origins, strings.ToLower(o))
+	}
+	for _, m := range methods {
+		p.methods = append(p.methods, strings.ToUpper(m))
+	}
+	for _, h := range headers {
+		p.headers = append(p.headers, strings.ToLower(h))
+	}
+	p.allowMethods = strings.Join(p.methods, ", ")
+	p.allowHeaders = strings.Join(headers, ", ")
+	return p
+}
+
+// AllowOrigin 通配符只匹配子域名, https://*.example.com 不匹配 https://example.com
+func (p *Policy) AllowOrigin(origin string) bool {
+	origin = strings.ToLower(origin)
+	for _, o := range p.origins {
+		if o == "*" || o == origin {
+			return true
+		}
+		scheme, host, ok := strings.Cut(o, "://*.")
+		if ok && strings.HasPrefix(origin, scheme+"://") && strings.HasSuffix(origin, "."+host) {
+			return true
+		}
+	}
+	return false
+}
+
+// Preflight 检查预检请求的方法与请求头, 通过时写入响应头
+func (p *Policy) Preflight(h http.Header, origin string, r *http.Request) bool {
+	if !slices.Contains(p.methods, strings.ToUpper(r.Header.Get("Access-Control-Request-Method"))) {
+		return false
+	}
+	for _, reqHeader := range strings.Split(r.Header.Get("Access-Control-Request-Headers"), ",") {
+		reqHeader = strings.ToLower(strings.TrimSpace(reqHeader))
+		if reqHeader != "" && !slices.Contains(p.headers, reqHeader) {
+			return false
+		}
+	}
+
+	p.Allow(h, origin)
+	h.Set("Access-Control-Allow-Methods", p.allowMethods)
+	h.Set("Access-Control-Allow-Headers", p.allowHeaders)
+	if p.maxAge > 0 {
+		h.Set("Access-Control-Max-Age", strconv.Itoa(int(p.maxAge.Seconds())))
+	}
+	return true
+}
+
+// Allow 写入允许跨域的响应头, 带凭据时不能使用 *
+func (p *Policy) Allow(h http.Header, origin string) {
+	if slices.Contains(p.origins, "*") && !p.credentials {
+		h.Set("Access-Control-Allow-Origin", "*")
+		return
+	}
+	h.Set("Access-Control-Allow-Origin", origin)
+	h.Add("Vary", "Origin")
+	if p.credentials {
+		h.Set("Access-Control-Allow-Credentials", "true")
+	}
+}
@@ -0,0 +1,61 @@
+package cors
+
+import (
+	"net/http"
+	"testing"
+	"time"
+)
+
+func TestAllowOrigin(t *testing.T) {
+	p := New([]string{"https://partner.com", "https://*.partner.io"}, nil, nil, true, 0)
+	for origin, want := range map[string]bool{
+		"https://partner.com":      true,
+		"https://PARTNER.com":      true,
+		"http://partner.com":       false,
+		"https://a.partner.io":     true,
+		"https://a.b.partner.io":   true,
+		"https://partner.io":       false,
+		"https://evilpartner.io":   false,
+		"http://a.partner.io":      false,
+		"https://partner.com.evil": false,
+	} {
+		if got := p.AllowOrigin(origin); got != want {
+			t.Errorf("%s: got %v, want %v", origin, got, want)
+		}
+	}
+
+	if !New(nil, nil, nil, false, 0).AllowOrigin("https://any.site") {
+		t.Error("empty origins should allow all")
+	}
+}
+
+func TestPreflight(t *testing.T) {
+	p := New([]string{"https://partner.com"}, []string{"POST"}, []string{"Content-Type", "X-48-Token"}, true, time.Hour)
+
+	r, _ := http.NewRequest(http.MethodOptions, "/", nil)
+	r.Header.Set("Access-Control-Request-Method", "POST")
+	r.Header.Set("Access-Control-Request-Headers", "content-type, x-48-token")
+	h := http.Header{}
+	if !p.Preflight(h, "https://partner.com", r) {
+		t.Fatal("preflight rejected")
+	}
+	if h.Get("Access-Control-Allow-Origin") != "https://partner.com" || h.Get("Access-Control-Allow-Credentials") != "true" || h.Get("Access-Control-Max-Age") != "3600" {
+		t.Fatalf("headers: %v", h)
+	}
+
+	r.Header.Set("Access-Control-Request-Headers", "content-type, x-secret")
+	if p.Preflight(http.Header{}, "https://partner.com", r) {
+		t.Fatal("preflight with disallowed header accepted")
+	}
+	r.Header.Set("Access-Control-Request-Method", "DELETE")
+	r.Header.Del("Access-Control-Request-Headers")
+	if p.Preflight(http.Header{}, "https://partner.com", r) {
+		t.Fatal("preflight with disallowed method accepted")
+	}
+
+	h = http.Header{}
+	New(nil, nil, nil, false, 0).Allow(h, "https://any.site")
+	if h.Get("Access-Control-Allow-Origin") != "*" {
+		t.Fatalf("wildcard: %v", h)
+	}
+}
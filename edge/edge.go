@@ -0,0 +1,99 @@
+package edge
+
+import (
+	"fmt"
+	"net/http"
+	"net/netip"
+	"strings"
+	"sync/atomic"
+
+	"github.com/48Club/service_agent/cidr"
+	"github.com/48Club/service_agent/cloudflare"
+)
+
+// Edge 一个 CDN / 负载均衡提供方, 只有来自其节点 IP 段的连接才信任其客户端 IP 头
+type Edge struct {
+	Name    string
+	Header  string   // 客户端 IP 头, 如 CF-Connecting-IP, Fastly-Client-IP, True-Client-IP, X-Forwarded-For
+	XFFHops int      // Header 为 X-Forwarded-For 时, 从右往左第几个是客户端 IP, 默认 1
+	Files   []string // IP 段文件, cloudflare 未配置时使用内置列表
+
+	ranges atomic.Pointer[cidr.Set]
+}
+
+func New(name, header string, xffHops int, files []string) (*Edge, error) {
+	if header == "" {
+		return nil, fmt.Errorf("edge %s: client_ip_header is required", name)
+	}
+	if xffHops <= 0 {
+		xffHops = 1
+	}
+	e := &Edge{Name: name, Header: http.CanonicalHeaderKey(header), XFFHops: xffHops, Files: files}
+	return e, e.Reload()
+}
+
+// Reload 重新读取 IP 段文件, 失败时保留原有列表
+func (e *Edge) Reload() error {
+	var (
+		s   *cidr.Set
+		err error
+	)
+	switch {
+	case len(e.Files) > 0:
+		s, err = cidr.LoadSet(e.Files)
+	case e.Name == "cloudflare":
+		s = cloudflare.Embedded()
+	default:
+		err = fmt.Errorf("edge %s: cidr_files is required", e.Name)
+	}
+	if err != nil {
+		return err
+	}
+	e.ranges.Store(s)
+	return nil
+}
+
+func (e *Edge) Ranges() *cidr.Set { return e.ranges.Load() }
+
+// clientIP 从请求头中取客户端 IP
+func (e *Edge) clientIP(h http.Header) (netip.Addr, bool) {
+	var ip string
+	if e.Header == "X-Forwarded-For" {
+		hops := []string{}
+		for _, v := range h.Values(e.Header) {
+			hops = append(hops, strings.Split(v, ",")...)
+		}
+		if len(hops) < e.XFFHops {
+			return netip.Addr{}, false
+		}
+		ip = hops[len(hops)-e.XFFHops]
+	} else {
+		ip = h.Get(e.Header)
+	}
+
+	addr, err := netip.ParseAddr(strings.TrimSpace(ip))
+	if err != nil {
+		return netip.Addr{}, false
+	}
+	return addr.Unmap(), true
+}
+
+// Trust 一个域名的信任配置
+type Trust struct {
+	Edges  []*Edge
+	Direct *cidr.Set // 允许直连的 IP 段, 如内网服务
+}
+
+// Resolve 返回客户端 IP, 连接既不来自受信任的 CDN 节点也不在直连白名单时返回 false
+func (t *Trust) Resolve(remote netip.Addr, h http.Header) (netip.Addr, bool) {
+	remote = remote.Unmap()
+	for _, e := range t.Edges {
+		if e.Ranges().Contains(remote) {
+			return e.clientIP(h)
+		}
+	}
+	if t.Direct != nil && t.Direct.Contains(remote) {
+		return remote, true
+	}
+	return netip.Addr{}, false
+}
@@ -0,0 +1,47 @@
+package edge
+
+import (
+	"net/http"
+	"net/netip"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/48Club/service_agent/cidr"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestResolve(t *testing.T) {
+	cf, err := New("cloudflare", "CF-Connecting-IP", 0, nil)
+	assert.Nil(t, err)
+
+	file := filepath.Join(t.TempDir(), "lb")
+	assert.Nil(t, os.WriteFile(file, []byte("10.0.0.0/24\n"), 0o644))
+	lb, err := New("lb", "X-Forwarded-For", 2, []string{file})
+	assert.Nil(t, err)
+
+	_, err = New("fastly", "Fastly-Client-IP", 0, nil)
+	assert.NotNil(t, err)
+
+	trust := &Trust{Edges: []*Edge{cf, lb}, Direct: cidr.SetOf([]netip.Prefix{netip.MustParsePrefix("192.168.0.0/16")})}
+	h := http.Header{}
+	h.Set("CF-Connecting-IP", "1.1.1.1")
+	h.Add("X-Forwarded-For", "2.2.2.2, 3.3.3.3")
+	h.Add("X-Forwarded-For", "4.4.4.4")
+
+	resolve := func(remote string) string {
+		addr, ok := trust.Resolve(netip.MustParseAddr(remote), h)
+		if !ok {
+			return ""
+		}
+		return addr.String()
+	}
+	assert.Equal(t, "1.1.1.1", resolve("172.68.242.51"))
+	assert.Equal(t, "3.3.3.3", resolve("10.0.0.1"))
+	assert.Equal(t, "192.168.1.1", resolve("192.168.1.1"))
+	assert.Equal(t, "", resolve("8.8.8.8"))
+
+	// CDN 节点未带客户端 IP 头
+	h.Del("CF-Connecting-IP")
+	assert.Equal(t, "", resolve("172.68.242.51"))
+}
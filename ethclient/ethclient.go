@@ -23,7 +23,7 @@ func init() {
 }
 
 func Send2Sentry(data []byte) ([]byte, error) {
-	resp, err := httpClient.Post(config.GlobalConfig.Sentry, "application/json", bytes.NewReader(data))
+	resp, err := httpClient.Post(config.Get().Sentry, "application/json", bytes.NewReader(data))
 	if err != nil {
 		return nil, err
 	}
@@ -0,0 +1,199 @@
+package gas
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"math/big"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/48Club/service_agent/metrics"
+	"github.com/ethereum/go-ethereum"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+)
+
+// 策略模式
+const (
+	Fixed      = "fixed"      // 固定值
+	Upstream   = "upstream"   // 上游节点的建议值乘以 multiplier
+	Percentile = "percentile" // 最近 blocks 个区块中 priority fee 第 percentile 百分位的中位数
+)
+
+// Chain 查询上游节点的建议值, 由 ethclient 实现
+type Chain interface {
+	SuggestGasPrice(ctx context.Context) (*big.Int, error)
+	SuggestGasTipCap(ctx context.Context) (*big.Int, error)
+	FeeHistory(ctx context.Context, blockCount uint64, lastBlock *big.Int, rewardPercentiles []float64) (*ethereum.FeeHistory, error)
+}
+
+// Policy eth_gasPrice, eth_maxPriorityFeePerGas 和 eth_feeHistory 的返回值策略
+// Min 和 Max 对所有模式生效, 为 0 表示不限制
+type Policy struct {
+	Mode       string
+	Value      *big.Int
+	Multiplier float64
+	Min        *big.Int
+	Max        *big.Int
+	Percentile float64
+	Blocks     uint64
+}
+
+// cacheTTL 上游查询结果的缓存时长, 避免每个请求都查询上游
+const cacheTTL = time.Second
+
+type cached struct {
+	value *big.Int
+	at    time.Time
+}
+
+var (
+	cacheMu sync.Mutex
+	cache   = map[string]cached{}
+)
+
+// lookup 读取缓存, 未命中时调用 fetch
+func lookup(key string, fetch func() (*big.Int, error)) (*big.Int, error) {
+	cacheMu.Lock()
+	c, ok := cache[key]
+	cacheMu.Unlock()
+	if ok && time.Since(c.at) < cacheTTL {
+		metrics.CacheHit("gas", true)
+		return c.value, nil
+	}
+	metrics.CacheHit("gas", false)
+
+	v, err := fetch()
+	if err != nil {
+		return nil, err
+	}
+	cacheMu.Lock()
+	cache[key] = cached{v, time.Now()}
+	cacheMu.Unlock()
+	return v, nil
+}
+
+// GasPrice eth_gasPrice 的返回值
+func (p *Policy) GasPrice(ctx context.Context, c Chain) (*big.Int, error) {
+	switch p.Mode {
+	case Fixed:
+		return p.clamp(p.Value), nil
+	case Percentile:
+		v, err := lookup(fmt.Sprintf("price:%d:%g", p.Blocks, p.Percentile), func() (*big.Int, error) {
+			return p.percentile(ctx, c, true)
+		})
+		if err != nil {
+			return nil, err
+		}
+		return p.clamp(v), nil
+	}
+	v, err := lookup("price", func() (*big.Int, error) { return c.SuggestGasPrice(ctx) })
+	if err != nil {
+		return nil, err
+	}
+	return p.adjust(v), nil
+}
+
+// GasTipCap eth_maxPriorityFeePerGas 的返回值
+func (p *Policy) GasTipCap(ctx context.Context, c Chain) (*big.Int, error) {
+	switch p.Mode {
+	case Fixed:
+		return p.clamp(p.Value), nil
+	case Percentile:
+		v, err := lookup(fmt.Sprintf("tip:%d:%g", p.Blocks, p.Percentile), func() (*big.Int, error) {
+			return p.percentile(ctx, c, false)
+		})
+		if err != nil {
+			return nil, err
+		}
+		return p.clamp(v), nil
+	}
+	v, err := lookup("tip", func() (*big.Int, error) { return c.SuggestGasTipCap(ctx) })
+	if err != nil {
+		return nil, err
+	}
+	return p.adjust(v), nil
+}
+
+// percentile 取每个区块的百分位 priority fee 的中位数, withBaseFee 时加上下一个区块的 base fee
+func (p *Policy) percentile(ctx context.Context, c Chain, withBaseFee bool) (*big.Int, error) {
+	fh, err := c.FeeHistory(ctx, p.Blocks, nil, []float64{p.Percentile})
+	if err != nil {
+		return nil, err
+	}
+	var tips []*big.Int
+	for _, r := range fh.Reward {
+		if len(r) > 0 && r[0] != nil {
+			tips = append(tips, r[0])
+		}
+	}
+	if len(tips) == 0 {
+		return nil, fmt.Errorf("fee history: no rewards in %d blocks", p.Blocks)
+	}
+	slices.SortFunc(tips, func(a, b *big.Int) int { return a.Cmp(b) })
+	v := new(big.Int).Set(tips[len(tips)/2])
+	if n := len(fh.BaseFee); withBaseFee && n > 0 && fh.BaseFee[n-1] != nil {
+		v.Add(v, fh.BaseFee[n-1])
+	}
+	return v, nil
+}
+
+// FeeHistory 按策略改写上游 eth_feeHistory 响应中的 reward, 其他字段原样保留
+// fixed 和 percentile 模式下所有 reward 替换为 eth_maxPriorityFeePerGas 的返回值, 与其保持一致
+func (p *Policy) FeeHistory(ctx context.Context, c Chain, result json.RawMessage) (json.RawMessage, error) {
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal(result, &fields); err != nil || fields == nil {
+		return result, err
+	}
+	raw, ok := fields["reward"]
+	if !ok {
+		return result, nil
+	}
+	var reward [][]*hexutil.Big
+	if err := json.Unmarshal(raw, &reward); err != nil {
+		return nil, err
+	}
+	var tip *big.Int
+	if p.Mode == Fixed || p.Mode == Percentile {
+		var err error
+		if tip, err = p.GasTipCap(ctx, c); err != nil {
+			return nil, err
+		}
+	}
+	for _, r := range reward {
+		for i, v := range r {
+			switch {
+			case tip != nil:
+				r[i] = (*hexutil.Big)(tip)
+			case v != nil:
+				r[i] = (*hexutil.Big)(p.adjust(v.ToInt()))
+			}
+		}
+	}
+	data, err := json.Marshal(reward)
+	if err != nil {
+		return nil, err
+	}
+	fields["reward"] = data
+	return json.Marshal(fields)
+}
+
+// adjust 乘以 multiplier 后限制在 [Min, Max]
+func (p *Policy) adjust(v *big.Int) *big.Int {
+	if p.Multiplier > 0 && p.Multiplier != 1 {
+		f, _ := new(big.Float).Mul(new(big.Float).SetInt(v), big.NewFloat(p.Multiplier)).Int(nil)
+		v = f
+	}
+	return p.clamp(v)
+}
+
+func (p *Policy) clamp(v *big.Int) *big.Int {
+	if p.Min != nil && p.Min.Sign() > 0 && v.Cmp(p.Min) < 0 {
+		return p.Min
+	}
+	if p.Max != nil && p.Max.Sign() > 0 && v.Cmp(p.Max) > 0 {
+		return p.Max
+	}
+	return v
+}
@@ -0,0 +1,86 @@
+package gas
+
+import (
+	"context"
+	"encoding/json"
+	"math/big"
+	"testing"
+
+	"github.com/ethereum/go-ethereum"
+)
+
+type fakeChain struct{ calls int }
+
+func (c *fakeChain) SuggestGasPrice(context.Context) (*big.Int, error) {
+	c.calls++
+	return big.NewInt(100), nil
+}
+
+func (c *fakeChain) SuggestGasTipCap(context.Context) (*big.Int, error) {
+	c.calls++
+	return big.NewInt(100), nil
+}
+
+func (c *fakeChain) FeeHistory(context.Context, uint64, *big.Int, []float64) (*ethereum.FeeHistory, error) {
+	c.calls++
+	return &ethereum.FeeHistory{
+		Reward:  [][]*big.Int{{big.NewInt(30)}, {big.NewInt(10)}, {big.NewInt(20)}},
+		BaseFee: []*big.Int{big.NewInt(0), big.NewInt(0), big.NewInt(0), big.NewInt(5)},
+	}, nil
+}
+
+func TestPolicy(t *testing.T) {
+	ctx := context.Background()
+	for _, c := range []struct {
+		name      string
+		policy    Policy
+		price     int64
+		tip       int64
+		fetchOnce bool
+	}{
+		{"fixed", Policy{Mode: Fixed, Value: big.NewInt(1)}, 1, 1, false},
+		{"fixed clamped", Policy{Mode: Fixed, Value: big.NewInt(1), Min: big.NewInt(50)}, 50, 50, false},
+		{"upstream", Policy{Mode: Upstream, Multiplier: 1.5}, 150, 150, true},
+		{"upstream max", Policy{Mode: Upstream, Multiplier: 2, Max: big.NewInt(120)}, 120, 120, true},
+		{"percentile", Policy{Mode: Percentile, Percentile: 50, Blocks: 3}, 25, 20, true},
+	} {
+		cache = map[string]cached{}
+		chain := &fakeChain{}
+		price, err := c.policy.GasPrice(ctx, chain)
+		if err != nil || price.Int64() != c.price {
+			t.Errorf("%s: price %v, %v", c.name, price, err)
+		}
+		tip, err := c.policy.GasTipCap(ctx, chain)
+		if err != nil || tip.Int64() != c.tip {
+			t.Errorf("%s: tip %v, %v", c.name, tip, err)
+		}
+		c.policy.GasPrice(ctx, chain)
+		if want := map[bool]int{true: 2, false: 0}[c.fetchOnce]; chain.calls != want {
+			t.Errorf("%s: %d upstream calls, want %d", c.name, chain.calls, want)
+		}
+	}
+}
+
+func TestFeeHistory(t *testing.T) {
+	upstream := json.RawMessage(`{"oldestBlock":"0x10","baseFeePerGas":["0x0","0x0"],"gasUsedRatio":[0.5],"reward":[["0x1","0x64"]]}`)
+	for _, c := range []struct {
+		policy Policy
+		want   string
+	}{
+		{Policy{Mode: Fixed, Value: big.NewInt(2)}, `[["0x2","0x2"]]`},
+		{Policy{Mode: Upstream, Multiplier: 2, Max: big.NewInt(150)}, `[["0x2","0x96"]]`},
+		{Policy{Mode: Percentile, Percentile: 50, Blocks: 3}, `[["0x14","0x14"]]`},
+		{Policy{Mode: Percentile, Percentile: 50, Blocks: 3, Min: big.NewInt(50)}, `[["0x32","0x32"]]`},
+	} {
+		cache = map[string]cached{}
+		got, err := c.policy.FeeHistory(context.Background(), &fakeChain{}, upstream)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var fields map[string]json.RawMessage
+		json.Unmarshal(got, &fields)
+		if string(fields["reward"]) != c.want || string(fields["oldestBlock"]) != `"0x10"` {
+			t.Errorf("%s: got %s", c.policy.Mode, got)
+		}
+	}
+}
@@ -0,0 +1,93 @@
+package handler
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"slices"
+
+	"github.com/48Club/service_agent/config"
+	"github.com/48Club/service_agent/tools"
+	"github.com/48Club/service_agent/tracing"
+	"github.com/48Club/service_agent/upstream"
+	"github.com/gin-gonic/gin"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/trace"
+)
+
+var httpClient = &http.Client{Transport: httpTransport}
+
+// postUpstream 由 agent 向上游发送批量请求, 用于部分请求已由 agent 响应, 需要合并结果的场景
+func postUpstream(ctx context.Context, up *upstream.Upstream, host string, body []byte) (batch []json.RawMessage, err error) {
+	ctx, span := tracing.Tracer.Start(ctx, "proxy.batch", trace.WithSpanKind(trace.SpanKindClient),
+		trace.WithAttributes(attribute.String("upstream", up.Name)))
+	defer func() {
+		if err != nil {
+			span.RecordError(err)
+			span.SetStatus(codes.Error, err.Error())
+		}
+		span.End()
+	}()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, up.URL, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	req.Host = host
+	req.Header.Set("Content-Type", "application/json")
+	otel.GetTextMapPropagator().Inject(ctx, propagation.HeaderCarrier(req.Header))
+
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	span.SetAttributes(attribute.Int("http.response.status_code", resp.StatusCode))
+
+	data, err := io.ReadAll(http.MaxBytesReader(nil, resp.Body, MaxResponseBodySize))
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(data, &batch); err != nil {
+		return nil, fmt.Errorf("upstream %s: unexpected batch response, status %d", up.Name, resp.StatusCode)
+	}
+	return batch, nil
+}
+
+// postPinned 将改写过区块标签的请求通过 HTTP 发给已达到该高度的上游, 单个请求按只有一项的批量请求发送
+func postPinned(ctx context.Context, host string, d *tools.Decoded) (any, error) {
+	up := config.Get().UpstreamPool.PickAt(d.Pinned)
+	if d.Batch {
+		batch, err := postUpstream(ctx, up, host, d.Forward())
+		if err != nil {
+			return nil, err
+		}
+		return d.Merge(batch), nil
+	}
+	batch, err := postUpstream(ctx, up, host, slices.Concat([]byte("["), d.Forward(), []byte("]")))
+	if err != nil {
+		return nil, err
+	}
+	if len(batch) != 1 {
+		return nil, fmt.Errorf("upstream %s: unexpected batch response", up.Name)
+	}
+	return batch[0], nil
+}
+
+// partialHandler 转发批量请求中需要上游处理的部分, 与 agent 的响应合并后返回
+func partialHandler(c *gin.Context, d *tools.Decoded, up *upstream.Upstream) {
+	c.Set("upstream", up.Name)
+	batch, err := postUpstream(c.Request.Context(), up, c.Request.Host, d.Forward())
+	if err != nil {
+		_ = c.Error(err)
+		c.AbortWithStatus(http.StatusBadGateway)
+		return
+	}
+	c.JSON(http.StatusOK, d.Merge(batch))
+}
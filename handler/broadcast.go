@@ -0,0 +1,146 @@
+package handler
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"sync"
+
+	"github.com/48Club/service_agent/broadcast"
+	"github.com/48Club/service_agent/config"
+	"github.com/48Club/service_agent/receipts"
+	"github.com/48Club/service_agent/tools"
+	"github.com/48Club/service_agent/tracing"
+	"github.com/48Club/service_agent/txcache"
+	"github.com/48Club/service_agent/types"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/rpc"
+	"go.opentelemetry.io/otel/attribute"
+)
+
+// broadcastTxs 广播模式或开启 tx_cache, receipts 时由 agent 发送校验通过的交易, 结果写入 d.Responses
+// 隐私模式的域名只发送给私有中继, 失败时也不转发给上游
+func broadcastTxs(ctx context.Context, host string, d *tools.Decoded) {
+	b := config.Get().Broadcaster
+	if b == nil && !txcache.Txs.Enabled() && receipts.Txs == nil {
+		return
+	}
+	var endpoints []*broadcast.Endpoint
+	pc, private := config.Get().PrivateTxFor(host)
+	switch {
+	case b == nil:
+	case private:
+		endpoints = b.Private()
+	default:
+		endpoints = b.Endpoints
+	}
+
+	var wg sync.WaitGroup
+	for i, tx := range d.Txs {
+		if tx == nil || d.Responses[i] != nil {
+			continue
+		}
+		wg.Go(func() {
+			ctx, span := tracing.Tracer.Start(ctx, "broadcast")
+			defer span.End()
+			span.SetAttributes(attribute.String("tx.hash", tx.Tx.Hash().Hex()), attribute.String("tx.from", tx.Sender.Hex()),
+				attribute.Bool("tx.private", private))
+
+			var onResult func(broadcast.Result)
+			if private {
+				onResult = broadcast.PrivateTxs.Track(tx.Tx.Hash(), tx.Raw, pc.FallbackBlocks, d.Caller)
+			}
+			var (
+				hash common.Hash
+				err  error
+			)
+			if b != nil {
+				hash, err = b.Send(ctx, endpoints, tx.Tx.Hash(), tx.Raw, onResult)
+			} else {
+				hash, err = sendUpstream(ctx, tx)
+			}
+			// 节点明确拒绝的交易同样缓存, 超时等临时错误允许客户端重试
+			var rpcErr rpc.Error
+			if err == nil || errors.As(err, &rpcErr) {
+				txcache.Txs.Store(tx.Tx.Hash(), hash, web3ErrorOrNil(err))
+			}
+			if err != nil {
+				if private {
+					broadcast.PrivateTxs.Fail(tx.Tx.Hash())
+				}
+				span.RecordError(err)
+				d.RespondError(i, web3Error(err))
+				return
+			}
+			d.Respond(i, hash)
+		})
+	}
+	wg.Wait()
+}
+
+// releaseRejected 被 agent 或节点拒绝的交易归还占用的替换次数
+func releaseRejected(d *tools.Decoded) {
+	for i, tx := range d.Txs {
+		if tx == nil {
+			continue
+		}
+		if _, failed := d.Responses[i]["error"]; failed {
+			txcache.Txs.Release(tx.Sender, tx.Tx.Nonce(), tx.Tx.Hash())
+		}
+	}
+}
+
+// releaseTxs 请求在解析后被限速拒绝时归还所有交易占用的替换次数
+func releaseTxs(d *tools.Decoded) {
+	for _, tx := range d.Txs {
+		if tx != nil {
+			txcache.Txs.Release(tx.Sender, tx.Tx.Nonce(), tx.Tx.Hash())
+		}
+	}
+}
+
+// recordTxs 开启 receipts 时记录节点已接受的交易, 写入在后台进行
+func recordTxs(key string, d *tools.Decoded) {
+	if receipts.Txs == nil {
+		return
+	}
+	for i, tx := range d.Txs {
+		if tx == nil || d.Responses[i] == nil {
+			continue
+		}
+		if _, failed := d.Responses[i]["error"]; failed {
+			continue
+		}
+		receipts.Txs.Record(tx.Tx, tx.Sender, key)
+	}
+}
+
+// sendUpstream 没有配置广播节点时由 agent 发送给上游, 与转发的效果相同
+func sendUpstream(ctx context.Context, tx *tools.RawTx) (hash common.Hash, err error) {
+	client, err := config.Get().UpstreamPool.Client(ctx)
+	if err != nil {
+		return
+	}
+	err = client.Client().CallContext(ctx, &hash, "eth_sendRawTransaction", hexutil.Encode(tx.Raw))
+	if err != nil && strings.Contains(err.Error(), "already known") {
+		return tx.Tx.Hash(), nil
+	}
+	return
+}
+
+func web3ErrorOrNil(err error) *types.Web3Error {
+	if err == nil {
+		return nil
+	}
+	return web3Error(err)
+}
+
+// web3Error 保留节点返回的 JSON-RPC 错误码
+func web3Error(err error) *types.Web3Error {
+	var rpcErr rpc.Error
+	if errors.As(err, &rpcErr) {
+		return &types.Web3Error{Code: rpcErr.ErrorCode(), Message: rpcErr.Error()}
+	}
+	return &types.Web3Error{Code: -32000, Message: err.Error()}
+}
@@ -0,0 +1,61 @@
+package handler
+
+import (
+	"context"
+	"sync"
+
+	"github.com/48Club/service_agent/bundle"
+	"github.com/48Club/service_agent/config"
+	"github.com/48Club/service_agent/metrics"
+	"github.com/48Club/service_agent/tools"
+	"github.com/48Club/service_agent/tracing"
+	"github.com/48Club/service_agent/types"
+	"go.opentelemetry.io/otel/attribute"
+)
+
+// forwardBundles 按 API key 限速后将 bundle 转发给 builder, 结果写入 d.Responses
+// 没有 key 的请求按 IP 计数
+func forwardBundles(ctx context.Context, host, ip, key string, d *tools.Decoded) {
+	bc := config.Get().Bundle
+	limiters := bc.LimitsFor(key)
+	id := caller(key, ip)
+
+	var wg sync.WaitGroup
+	for i, b := range d.Bundles {
+		if b == nil || d.Responses[i] != nil {
+			continue
+		}
+		if limiters != nil && limiters.Allow(id, false, 1, nil) {
+			metrics.RateLimitRejections.WithLabelValues(hostLabel(host), "bundle").Inc()
+			d.RespondError(i, &types.Web3Error{Code: -32005, Message: "bundle rate limit exceeded"})
+			continue
+		}
+		wg.Go(func() {
+			ctx, span := tracing.Tracer.Start(ctx, "bundle")
+			defer span.End()
+			span.SetAttributes(attribute.String("rpc.method", b.Method), attribute.Int("bundle.txs", len(b.Txs)))
+
+			var (
+				result any
+				err    error
+			)
+			switch b.Method {
+			case bundle.MethodCall:
+				result, err = bc.Builders.Call(ctx, b.Method, b.Params)
+			default:
+				var c *bundle.Consolidated
+				if c, err = bc.Builders.Send(ctx, b.Method, b.Params); err == nil {
+					c.BundleHash = b.Hash
+					result = c
+				}
+			}
+			if err != nil {
+				span.RecordError(err)
+				d.RespondError(i, web3Error(err))
+				return
+			}
+			d.Respond(i, result)
+		})
+	}
+	wg.Wait()
+}
@@ -0,0 +1,38 @@
+package handler
+
+import (
+	"net/http"
+
+	"github.com/48Club/service_agent/config"
+	"github.com/gin-gonic/gin"
+)
+
+// CORSMiddleware 按 API key 与域名处理跨域, 不允许的预检请求在到达 AnyHandler 之前拒绝
+// 不允许的来源发起的普通请求只是不返回跨域响应头, 由浏览器拦截
+func CORSMiddleware(c *gin.Context) {
+	origin := c.GetHeader("Origin")
+	if origin == "" {
+		return
+	}
+
+	cfg := config.Get()
+	key := requestKey(c)
+	preflight := c.Request.Method == http.MethodOptions && c.GetHeader("Access-Control-Request-Method") != ""
+	if exception, ok := cfg.ExceptionLimiterMap[c.Request.Host]; ok && preflight && key == "" {
+		// 预检请求不携带 X-48-Token, 需要 token 的域名只接受该 key 的请求, 按该 key 的配置预检
+		key = exception.Domain
+	}
+	policy := cfg.CORSFor(key, c.Request.Host)
+	if !preflight {
+		if policy.AllowOrigin(origin) {
+			policy.Allow(c.Writer.Header(), origin)
+		}
+		return
+	}
+
+	if !policy.AllowOrigin(origin) || !policy.Preflight(c.Writer.Header(), origin, c.Request) {
+		c.AbortWithStatus(http.StatusForbidden)
+		return
+	}
+	c.AbortWithStatus(http.StatusNoContent)
+}
@@ -0,0 +1,79 @@
+package handler
+
+import (
+	"context"
+	"encoding/json"
+	"math/big"
+	"sync"
+
+	"github.com/48Club/service_agent/config"
+	"github.com/48Club/service_agent/gas"
+	"github.com/48Club/service_agent/tools"
+	"github.com/48Club/service_agent/tracing"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"go.opentelemetry.io/otel/attribute"
+)
+
+// applyGasPolicy 按域名的 gas 策略由 agent 响应 eth_gasPrice, eth_maxPriorityFeePerGas 和 eth_feeHistory
+func applyGasPolicy(ctx context.Context, host string, d *tools.Decoded) {
+	policy := config.Get().GasPolicyFor(host)
+	if policy == nil {
+		return
+	}
+
+	var wg sync.WaitGroup
+	for i, req := range d.Requests {
+		if d.Responses[i] != nil {
+			continue
+		}
+		switch req.Method {
+		case "eth_gasPrice", "eth_maxPriorityFeePerGas":
+			if policy.Mode == gas.Fixed {
+				// fixed 模式不查询上游, 上游不可用时仍由 agent 响应
+				v, _ := policy.GasPrice(ctx, nil)
+				d.Respond(i, (*hexutil.Big)(v))
+				continue
+			}
+		case "eth_feeHistory":
+		default:
+			continue
+		}
+		wg.Go(func() {
+			ctx, span := tracing.Tracer.Start(ctx, "gas_policy")
+			defer span.End()
+			span.SetAttributes(attribute.String("rpc.method", req.Method), attribute.String("gas.mode", policy.Mode))
+
+			client, err := config.Get().UpstreamPool.Client(ctx)
+			if err != nil {
+				span.RecordError(err)
+				d.RespondError(i, web3Error(err))
+				return
+			}
+			var result any
+			switch req.Method {
+			case "eth_gasPrice":
+				var v *big.Int
+				if v, err = policy.GasPrice(ctx, client); err == nil {
+					result = (*hexutil.Big)(v)
+				}
+			case "eth_maxPriorityFeePerGas":
+				var v *big.Int
+				if v, err = policy.GasTipCap(ctx, client); err == nil {
+					result = (*hexutil.Big)(v)
+				}
+			case "eth_feeHistory":
+				var raw json.RawMessage
+				if err = client.Client().CallContext(ctx, &raw, req.Method, req.Params...); err == nil {
+					result, err = policy.FeeHistory(ctx, client, raw)
+				}
+			}
+			if err != nil {
+				span.RecordError(err)
+				d.RespondError(i, web3Error(err))
+				return
+			}
+			d.Respond(i, result)
+		})
+	}
+	wg.Wait()
+}
@@ -2,71 +2,161 @@ package handler
 
 import (
 	"bytes"
+	"context"
+	"crypto/x509"
 	"fmt"
 	"io"
 	"log"
+	"net"
 	"net/http"
 	"net/http/httputil"
 	"net/url"
+	"strconv"
 	"strings"
 	"time"
 
+	"github.com/48Club/service_agent/acl"
+	"github.com/48Club/service_agent/cidr"
 	"github.com/48Club/service_agent/config"
 	"github.com/48Club/service_agent/limit"
+	"github.com/48Club/service_agent/metrics"
+	"github.com/48Club/service_agent/rules"
+	"github.com/48Club/service_agent/server"
 	"github.com/48Club/service_agent/tools"
+	"github.com/48Club/service_agent/tracing"
 	"github.com/48Club/service_agent/types"
-	mapset "github.com/deckarep/golang-set/v2"
+	"github.com/48Club/service_agent/upstream"
 	"github.com/gin-gonic/gin"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/trace"
 )
 
-var (
-	normalRequestStatus = mapset.NewSet(http.StatusOK, http.StatusNoContent, http.StatusTooManyRequests, http.StatusUnprocessableEntity)
-)
+func MetricsMiddleware(c *gin.Context) {
+	start := time.Now()
+	c.Next()
 
-func CustomLoggerMiddleware(c *gin.Context) {
-	defer func() {
-		statusCode := c.Writer.Status()
-		if normalRequestStatus.ContainsOne(statusCode) {
-			return
-		}
+	host := hostLabel(c.Request.Host)
+	metrics.Requests.WithLabelValues(host, c.Request.Method, strconv.Itoa(c.Writer.Status()), c.GetString("upstream")).Inc()
 
-		if c.IsWebsocket() && statusCode == http.StatusBadRequest {
-			return
+	if methods := c.GetStringSlice("rpc_methods"); len(methods) > 0 {
+		method := methods[0]
+		if c.GetInt("batch_size") > 0 {
+			method = "batch"
 		}
+		metrics.RPCDuration.WithLabelValues(host, metrics.MethodLabel(method)).Observe(time.Since(start).Seconds())
+	}
+}
 
-		gin.LoggerWithConfig(gin.LoggerConfig{Formatter: func(param gin.LogFormatterParams) string {
-			var statusColor, methodColor, resetColor string
-			if param.IsOutputColor() {
-				statusColor = param.StatusCodeColor()
-				methodColor = param.MethodColor()
-				resetColor = param.ResetColor()
-			}
+// hostLabel 非服务域名统一记为 other, 避免任意 Host 头导致标签基数爆炸
+func hostLabel(host string) string {
+	if !tools.IsRpc(host, config.Get().Domains) {
+		return "other"
+	}
+	return host
+}
 
-			if param.Latency > time.Minute {
-				param.Latency = param.Latency.Truncate(time.Second)
-			}
-			return fmt.Sprintf("[GIN] %v | %s(%s%3d%s) | %13v | %15s |%s %-7s %s %#v\n%s",
-				param.TimeStamp.Format("2006/01/02 15:04:05"),
-				param.Request.Host,
-				statusColor, param.StatusCode, resetColor,
-				param.Latency,
-				param.ClientIP,
-				methodColor, param.Method, resetColor,
-				param.Path,
-				param.ErrorMessage,
-			)
-		}})(c)
-	}()
-	c.Next()
+// caller 调用方标识, 有 API key 时为 key, 否则为 IP
+func caller(key, ip string) string {
+	if key != "" {
+		return key
+	}
+	return ip
 }
 
 func CheckHeader(c *gin.Context) {
-	if exceptionLimiter, ok := config.GlobalConfig.ExceptionLimiterMap[c.Request.Host]; ok {
+	cert := clientCert(c)
+	if cert == nil && config.Get().TLS.RequireClientCert(stripPort(c.Request.Host)) {
+		c.AbortWithStatus(http.StatusForbidden)
+		return
+	}
+	// 客户端证书映射到 API key 时以证书代替 token, 使用该 key 的限速
+	if key, ok := clientCertKey(cert); ok {
+		if acl.KeyBans.IsBanned(key) {
+			c.AbortWithStatus(http.StatusForbidden)
+			return
+		}
+		c.Set("key", key)
+		if _, ok := c.Get("profile"); !ok {
+			c.Set("profile", key)
+		}
+		return
+	}
+
+	if exceptionLimiter, ok := config.Get().ExceptionLimiterMap[c.Request.Host]; ok {
 		if c.GetHeader("X-48-Token") != exceptionLimiter.XToken {
 			c.AbortWithStatus(http.StatusForbidden)
 			return
 		}
+		if acl.KeyBans.IsBanned(exceptionLimiter.Domain) {
+			c.AbortWithStatus(http.StatusForbidden)
+			return
+		}
+		c.Set("key", exceptionLimiter.Domain)
+	}
+}
+
+// CheckRulesMiddleware 按域名的请求头规则放行, 拒绝或指定限速配置, 白名单 IP 不检查
+func CheckRulesMiddleware(c *gin.Context) {
+	if c.GetBool("allowlisted") {
+		return
+	}
+	rule := config.Get().RulesFor(c.Request.Host).Match(c.Request.Header)
+	if rule == nil {
+		return
+	}
+	metrics.HeaderRuleMatches.WithLabelValues(hostLabel(c.Request.Host), rule.Name, rule.Action).Inc()
+	c.Set("rule", rule.Name)
+
+	switch rule.Action {
+	case rules.Deny:
+		c.AbortWithStatus(http.StatusForbidden)
+	case rules.Profile:
+		c.Set("profile", rule.Profile)
+	}
+}
+
+// clientCert 返回已验证的客户端证书, SNI 与 Host 不一致时不认, 避免借用其他域名的 CA
+func clientCert(c *gin.Context) *x509.Certificate {
+	state := c.Request.TLS
+	if state == nil || len(state.VerifiedChains) == 0 || !strings.EqualFold(state.ServerName, stripPort(c.Request.Host)) {
+		return nil
+	}
+	return state.VerifiedChains[0][0]
+}
+
+// stripPort 去掉 Host 中的端口, 非 443 端口的监听器上 Host 带端口而 SNI 不带
+func stripPort(host string) string {
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		return h
+	}
+	return host
+}
+
+// clientCertKey 按 subject 或 CN 查找证书对应的 API key
+func clientCertKey(cert *x509.Certificate) (string, bool) {
+	if cert == nil {
+		return "", false
+	}
+	keys := config.Get().TLS.ClientKeys
+	if key, ok := keys[cert.Subject.String()]; ok {
+		return key, true
+	}
+	key, ok := keys[cert.Subject.CommonName]
+	return key, ok
+}
+
+// requestKey 返回请求携带的 API key (客户端证书或 X-48-Token), 不检查封禁, 没有时返回空
+func requestKey(c *gin.Context) string {
+	if key, ok := clientCertKey(clientCert(c)); ok {
+		return key
 	}
+	if exception, ok := config.Get().ExceptionLimiterMap[c.Request.Host]; ok && c.GetHeader("X-48-Token") == exception.XToken {
+		return exception.Domain
+	}
+	return ""
 }
 
 func CustomRecoveryMiddleware(c *gin.Context) {
@@ -105,9 +195,43 @@ func SetMaxRequestBodySize(c *gin.Context) {
 	c.Request.Body = io.NopCloser(bytes.NewReader(body))
 }
 
+const unlimitedProfile = "unlimited"
+
+// CheckACLMiddleware 在读取请求体之前检查黑白名单
+func CheckACLMiddleware(c *gin.Context) {
+	addr, trusted := tools.ClientAddr(c)
+	if !trusted {
+		return // 由 CheckIPMiddleware 处理
+	}
+
+	if acl.Deny.Contains(addr) {
+		c.Set("ip", tools.FormatIP(addr.String()))
+		c.AbortWithStatus(http.StatusForbidden)
+		return
+	}
+
+	if acl.Allow.Contains(addr) {
+		profile := config.Get().AllowlistProfile
+		if profile == "" {
+			profile = unlimitedProfile
+		}
+		c.Set("profile", profile)
+		c.Set("allowlisted", true)
+		return
+	}
+
+	if l := server.ListenerOf(c.Request.Context()); l != nil && l.Profile != "" {
+		c.Set("profile", l.Profile)
+	}
+}
+
 func CheckIPMiddleware(c *gin.Context) {
-	userIP, fromCDN := tools.CheckGinIP(c)
-	if !fromCDN && config.GlobalConfig.CDNPlatforms == "" {
+	ctx, span := tracing.Tracer.Start(c.Request.Context(), "CheckIPMiddleware")
+	defer span.End()
+
+	userIP, trusted := tools.CheckGinIP(c)
+	span.SetAttributes(attribute.String("client.address", userIP), attribute.Bool("trusted", trusted))
+	if !trusted {
 		c.AbortWithStatus(http.StatusForbidden)
 		return
 	}
@@ -119,7 +243,7 @@ func CheckIPMiddleware(c *gin.Context) {
 	}
 
 	var limitHeader = types.LimitResponse{}
-	tooManyRequests, _ := LimitMiddleware(userIP, true, 1, &limitHeader, c.Request.Host)
+	tooManyRequests := tracedLimit(ctx, userIP, true, 1, &limitHeader, c.Request.Host, c.GetString("profile"))
 
 	limitHeader.AddHeader(c)
 
@@ -127,20 +251,61 @@ func CheckIPMiddleware(c *gin.Context) {
 	c.Request.Header.Set("X-Forwarded-For", userIP)
 
 	if tooManyRequests {
+		penalize(userIP, c.GetString("key"))
 		c.AbortWithStatus(http.StatusTooManyRequests)
 		return
 	}
 }
 
-func LimitMiddleware(ip string, pass bool, count int, res *types.LimitResponse, hostname string) (bool, func(string)) {
-	if exceptionLimiter, ok := config.GlobalConfig.ExceptionLimiterMap[hostname]; ok {
-		return exceptionLimiter.Limter.Allow(ip, pass, count, res), exceptionLimiter.Limter.AllowPassCheck
+// penalize 记录一次超限, 达到阈值后封禁 IP, 开启 penalty.keys 时同时封禁 API key
+func penalize(ip, key string) {
+	if d, level, ban := acl.Penalties.Strike(ip); ban {
+		if p, err := cidr.ParsePrefix(ip); err == nil {
+			acl.Deny.Add(p, d, fmt.Sprintf("penalty level %d", level))
+			metrics.PenaltyBans.WithLabelValues("ip").Inc()
+		}
 	}
-	return limit.Limits.Allow(ip, pass, count, res), limit.Limits.AllowPassCheck
+	if key == "" || !config.Get().Penalty.Keys {
+		return
+	}
+	if d, level, ban := acl.Penalties.Strike(acl.PenaltyKey(key)); ban {
+		acl.KeyBans.Ban(key, d, fmt.Sprintf("penalty level %d", level))
+		metrics.PenaltyBans.WithLabelValues("key").Inc()
+	}
+}
+
+func LimitMiddleware(ip string, pass bool, count int, res *types.LimitResponse, hostname, profile string) (bool, func(string)) {
+	profile, limiters := limiterProfile(hostname, profile)
+	if limiters == nil {
+		return false, func(string) {}
+	}
+	tooManyRequests := limiters.Allow(ip, pass, count, res)
+	if tooManyRequests {
+		metrics.RateLimitRejections.WithLabelValues(hostLabel(hostname), profile).Inc()
+	}
+	return tooManyRequests, limiters.AllowPassCheck
+}
+
+// limiterProfile 返回请求对应的限速器及其名称, 优先级: 请求指定的 profile > 域名的例外限速器 > 默认
+// unlimited 不限速, 返回 nil
+func limiterProfile(hostname, profile string) (string, limit.IPBasedRateLimiters) {
+	if profile == unlimitedProfile {
+		return profile, nil
+	}
+	if limiters, ok := config.Get().LimitProfiles[profile]; ok {
+		return profile, limiters
+	}
+	if exceptionLimiter, ok := config.Get().ExceptionLimiterMap[profile]; ok {
+		return "exception", exceptionLimiter.Limter
+	}
+	if exceptionLimiter, ok := config.Get().ExceptionLimiterMap[hostname]; ok {
+		return "exception", exceptionLimiter.Limter
+	}
+	return "default", limit.Limits
 }
 
 func AnyHandler(c *gin.Context) {
-	if !tools.IsRpc(c.Request.Host, config.GlobalConfig.Domains) {
+	if !tools.IsRpc(c.Request.Host, config.Get().Domains) {
 		c.AbortWithStatus(http.StatusForbidden)
 		return
 	}
@@ -151,57 +316,91 @@ func AnyHandler(c *gin.Context) {
 		defer c.Request.Body.Close()
 	}
 
+	up := config.Get().UpstreamPool.Pick()
+
 	switch c.Request.Method {
-	case http.MethodHead, http.MethodOptions:
-		// Header requests, check rpc status
+	case http.MethodHead:
+		// Header requests, check rpc status, 探针请使用管理端口的 /readyz
 		c.AbortWithStatus(tools.GetRpcStatus())
+	case http.MethodOptions:
+		// 预检请求已由 cors 中间件处理
+		c.AbortWithStatus(http.StatusNoContent)
 	case http.MethodPost:
-		rpcHandler(c, body)
+		rpcHandler(c, body, up)
 	case http.MethodGet:
 		if c.Request.URL.Path == "/ws/" && c.IsWebsocket() {
-			handleWebSocket(c, fmt.Sprintf("ws://%s", strings.Split(config.GlobalConfig.Sentry, "://")[1]))
+			c.Set("upstream", up.Name)
+			handleWebSocket(c, up)
 		}
 		fallthrough
 	default:
-		proxyHandler(c, body, config.GlobalConfig.Sentry)
+		proxyHandler(c, body, up)
 	}
 }
 
-func addLimitBatchReq(ip string, reqCount int, h string) bool {
-	if _, ok := config.GlobalConfig.ExceptionLimiterMap[h]; !ok && reqCount > config.GlobalConfig.MaxBatchQuery {
+func addLimitBatchReq(ctx context.Context, ip string, reqCount int, h, profile string) bool {
+	if _, ok := config.Get().ExceptionLimiterMap[h]; !ok && profile != unlimitedProfile && reqCount > config.Get().MaxBatchQuery {
+		metrics.RateLimitRejections.WithLabelValues(hostLabel(h), "max_batch_query").Inc()
 		return true
 	}
-	b, _ := LimitMiddleware(ip, false, reqCount, nil, h)
-	return b
+	return tracedLimit(ctx, ip, false, reqCount, nil, h, profile)
 }
 
-func rpcHandler(c *gin.Context, body []byte) {
-	resp, buildRespByAgent, batchCount, skipLimit := tools.DecodeRequestBody(c.Request.Host, body)
-	if !skipLimit {
+func rpcHandler(c *gin.Context, body []byte, up *upstream.Upstream) {
+	d := tracedDecodeRequestBody(c.Request.Context(), c.Request.Host, caller(c.GetString("key"), c.GetString("ip")), body)
+	c.Set("rpc_methods", d.Methods)
+	if d.Batch {
+		c.Set("batch_size", len(d.Methods))
+	}
+	if !d.SkipLimit {
 		// 统计限速
-		if batchCount > 0 {
+		if d.BatchCount > 0 {
 			// 统计批量请求中非 eth_sendRawTransaction 的请求数量
-			if addLimitBatchReq(c.GetString("ip"), batchCount, c.Request.Host) {
+			if addLimitBatchReq(c.Request.Context(), c.GetString("ip"), d.BatchCount, c.Request.Host, c.GetString("profile")) {
+				releaseTxs(d)
+				penalize(c.GetString("ip"), c.GetString("key"))
 				c.AbortWithStatus(http.StatusTooManyRequests)
 				return
 			}
 		}
 	}
-	if buildRespByAgent {
-		c.JSON(http.StatusOK, resp)
+	applyGasPolicy(c.Request.Context(), c.Request.Host, d)
+	splitLogs(c.Request.Context(), c.Request.Host, d)
+	checkSenders(c.Request.Context(), c.Request.Host, d)
+	broadcastTxs(c.Request.Context(), c.Request.Host, d)
+	releaseRejected(d)
+	recordTxs(c.GetString("key"), d)
+	forwardBundles(c.Request.Context(), c.Request.Host, c.GetString("ip"), c.GetString("key"), d)
+	if d.Local() {
+		c.Set("upstream", "agent")
+		c.JSON(http.StatusOK, d.Response())
+		return
+	}
+	if d.Pinned > 0 {
+		// 改写后的区块号可能高于落后的上游, 只发给已达到该高度的上游
+		up = config.Get().UpstreamPool.PickAt(d.Pinned)
+	}
+	if d.Partial() {
+		partialHandler(c, d, up)
 		return
 	}
 
-	proxyHandler(c, body, config.GlobalConfig.Sentry)
+	proxyHandler(c, d.Forward(), up)
 }
 
-func proxyHandler(c *gin.Context, body []byte, toHost string) {
+func proxyHandler(c *gin.Context, body []byte, up *upstream.Upstream) {
 	if c.IsWebsocket() {
 		c.AbortWithStatus(http.StatusBadRequest)
 		return
 	}
 
-	target, _ := url.Parse(toHost)
+	c.Set("upstream", up.Name)
+	target, _ := url.Parse(up.URL)
+
+	ctx, span := tracing.Tracer.Start(c.Request.Context(), "proxy", trace.WithSpanKind(trace.SpanKindClient),
+		trace.WithAttributes(attribute.String("upstream", up.Name), attribute.String("server.address", target.Host)))
+	defer span.End()
+
 	proxy := &httputil.ReverseProxy{
 		Transport: httpTransport,
 		Rewrite: func(r *httputil.ProxyRequest) {
@@ -225,10 +424,14 @@ func proxyHandler(c *gin.Context, body []byte, toHost string) {
 				req.Header.Set("X-Forwarded-Proto", "http")
 			}
 
+			// 向上游传递 W3C traceparent
+			otel.GetTextMapPropagator().Inject(ctx, propagation.HeaderCarrier(req.Header))
+
 			req.ContentLength = int64(len(body))
 			req.Body = io.NopCloser(bytes.NewReader(body))
 		},
 		ModifyResponse: func(resp *http.Response) error {
+			span.SetAttributes(attribute.Int("http.response.status_code", resp.StatusCode))
 			resp.Body = http.MaxBytesReader(nil, resp.Body, MaxResponseBodySize)
 			resp.Header.Del("Access-Control-Allow-Origin")
 			if resp.ContentLength <= 0 {
@@ -237,6 +440,8 @@ func proxyHandler(c *gin.Context, body []byte, toHost string) {
 			return nil
 		},
 		ErrorHandler: func(w http.ResponseWriter, r *http.Request, err error) {
+			span.RecordError(err)
+			span.SetStatus(codes.Error, err.Error())
 			c.AbortWithStatus(http.StatusBadGateway)
 		},
 	}
@@ -0,0 +1,91 @@
+package handler
+
+import (
+	"io"
+	"log/slog"
+	"math/rand/v2"
+	"net/http"
+	"os"
+	"time"
+
+	"github.com/48Club/service_agent/config"
+	"github.com/gin-gonic/gin"
+	"gopkg.in/natefinch/lumberjack.v2"
+)
+
+var accessLogger *slog.Logger
+
+func init() {
+	var w io.Writer = os.Stdout
+	if cfg := config.Get().AccessLog; cfg.Output != "" && cfg.Output != "stdout" {
+		w = &lumberjack.Logger{
+			Filename:   cfg.Output,
+			MaxSize:    cfg.MaxSize,
+			MaxBackups: cfg.MaxBackups,
+			MaxAge:     cfg.MaxAge,
+		}
+	}
+	accessLogger = slog.New(slog.NewJSONHandler(w, nil))
+}
+
+// CustomLoggerMiddleware 以 JSON 格式记录访问日志, 成功请求按 success_sample_rate 采样, 错误请求全部记录
+func CustomLoggerMiddleware(c *gin.Context) {
+	start := time.Now()
+	body := &countingReader{ReadCloser: c.Request.Body}
+	c.Request.Body = body
+	c.Next()
+
+	statusCode := c.Writer.Status()
+	if c.IsWebsocket() && statusCode == http.StatusBadRequest {
+		// websocket 连接关闭后 proxyHandler 返回的 400, 不是真正的错误
+		return
+	}
+	if statusCode < http.StatusBadRequest && rand.Float64() >= *config.Get().AccessLog.SuccessSampleRate {
+		return
+	}
+
+	latency := time.Since(start)
+
+	attrs := []slog.Attr{
+		slog.String("host", c.Request.Host),
+		slog.String("ip", c.GetString("ip")),
+		slog.String("method", c.Request.Method),
+		slog.String("path", c.Request.URL.Path),
+		slog.Int("status", statusCode),
+		slog.Float64("latency_ms", float64(latency.Microseconds())/1e3),
+		slog.Int64("bytes_in", body.n),
+		slog.Int("bytes_out", max(c.Writer.Size(), 0)),
+	}
+	if key := c.GetString("key"); key != "" {
+		attrs = append(attrs, slog.String("key", key))
+	}
+	if rule := c.GetString("rule"); rule != "" {
+		attrs = append(attrs, slog.String("rule", rule))
+	}
+	if methods := c.GetStringSlice("rpc_methods"); len(methods) > 0 {
+		attrs = append(attrs, slog.Any("rpc_methods", methods))
+	}
+	if batchSize := c.GetInt("batch_size"); batchSize > 0 {
+		attrs = append(attrs, slog.Int("batch_size", batchSize))
+	}
+	if upstream := c.GetString("upstream"); upstream != "" {
+		attrs = append(attrs, slog.String("upstream", upstream))
+	}
+	if errs := c.Errors.String(); errs != "" {
+		attrs = append(attrs, slog.String("error", errs))
+	}
+
+	accessLogger.LogAttrs(c.Request.Context(), slog.LevelInfo, "access", attrs...)
+}
+
+// countingReader 统计实际读取的请求体字节数, chunked 请求的 ContentLength 为 -1
+type countingReader struct {
+	io.ReadCloser
+	n int64
+}
+
+func (r *countingReader) Read(p []byte) (int, error) {
+	n, err := r.ReadCloser.Read(p)
+	r.n += int64(n)
+	return n, err
+}
@@ -0,0 +1,58 @@
+package handler
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"sync"
+
+	"github.com/48Club/service_agent/config"
+	"github.com/48Club/service_agent/logs"
+	"github.com/48Club/service_agent/tools"
+	"github.com/48Club/service_agent/tracing"
+	"github.com/48Club/service_agent/types"
+	"go.opentelemetry.io/otel/attribute"
+)
+
+// splitLogs 将超过 chunk_size 的 eth_getLogs 拆分后并发发送给上游, 按区块顺序拼接结果
+func splitLogs(ctx context.Context, host string, d *tools.Decoded) {
+	l := config.Get().LogsFor(host)
+	if l == nil {
+		// 解析后配置被重载, 按原请求转发
+		return
+	}
+	var wg sync.WaitGroup
+	for i, q := range d.LogQueries {
+		if q == nil || d.Responses[i] != nil {
+			continue
+		}
+		wg.Go(func() {
+			ctx, span := tracing.Tracer.Start(ctx, "split_logs")
+			defer span.End()
+			span.SetAttributes(attribute.Int64("logs.from", int64(q.From)), attribute.Int64("logs.to", int64(q.To)))
+
+			client, err := config.Get().UpstreamPool.Client(ctx)
+			if err != nil {
+				span.RecordError(err)
+				d.RespondError(i, web3Error(err))
+				return
+			}
+			result, err := q.Run(ctx, l.ChunkSize, l.Concurrency, MaxResponseBodySize, func(ctx context.Context, filter map[string]any) (json.RawMessage, error) {
+				var raw json.RawMessage
+				err := client.Client().CallContext(ctx, &raw, "eth_getLogs", filter)
+				return raw, err
+			})
+			if err != nil {
+				span.RecordError(err)
+				if errors.Is(err, logs.ErrTooLarge) {
+					d.RespondError(i, &types.Web3Error{Code: -32005, Message: err.Error()})
+					return
+				}
+				d.RespondError(i, web3Error(err))
+				return
+			}
+			d.Respond(i, result)
+		})
+	}
+	wg.Wait()
+}
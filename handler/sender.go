@@ -0,0 +1,66 @@
+package handler
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/48Club/service_agent/config"
+	"github.com/48Club/service_agent/metrics"
+	"github.com/48Club/service_agent/tools"
+	"github.com/48Club/service_agent/tracing"
+	"github.com/48Club/service_agent/types"
+	"github.com/ethereum/go-ethereum/common"
+	"go.opentelemetry.io/otel/attribute"
+)
+
+// checkSenders 按发送方限速并检查 nonce 间隔, 未通过的交易由 agent 返回错误
+// 白名单中的发送方不受限制
+func checkSenders(ctx context.Context, host string, d *tools.Decoded) {
+	sl := config.Get().SenderLimit
+	if len(sl.Limits) == 0 && sl.MaxNonceGap == 0 {
+		return
+	}
+
+	for i, tx := range d.Txs {
+		if tx == nil || d.Responses[i] != nil || sl.Allow.ContainsOne(tx.Sender) {
+			continue
+		}
+		if len(sl.Limits) > 0 && sl.Limits.Allow(tx.Sender.Hex(), false, 1, nil) {
+			metrics.RateLimitRejections.WithLabelValues(hostLabel(host), "sender").Inc()
+			d.RespondError(i, &types.Web3Error{Code: -32005, Message: "sender rate limit exceeded"})
+			continue
+		}
+		if sl.MaxNonceGap > 0 {
+			if err := checkNonceGap(ctx, tx, sl.MaxNonceGap); err != nil {
+				metrics.RateLimitRejections.WithLabelValues(hostLabel(host), "nonce_gap").Inc()
+				d.RespondError(i, err)
+			}
+		}
+	}
+}
+
+// pendingNonceAt 查询发送方的 pending nonce, 测试时替换
+var pendingNonceAt = func(ctx context.Context, sender common.Address) (uint64, error) {
+	client, err := config.Get().UpstreamPool.Client(ctx)
+	if err != nil {
+		return 0, err
+	}
+	return client.PendingNonceAt(ctx, sender)
+}
+
+// checkNonceGap 查询发送方的 pending nonce, 查询失败时放行
+func checkNonceGap(ctx context.Context, tx *tools.RawTx, maxGap uint64) *types.Web3Error {
+	ctx, span := tracing.Tracer.Start(ctx, "nonce_gap")
+	defer span.End()
+	span.SetAttributes(attribute.String("tx.from", tx.Sender.Hex()))
+
+	pending, err := pendingNonceAt(ctx, tx.Sender)
+	if err != nil {
+		span.RecordError(err)
+		return nil
+	}
+	if nonce := tx.Tx.Nonce(); nonce > pending+maxGap {
+		return &types.Web3Error{Code: -32000, Message: fmt.Sprintf("nonce too high: address %s, tx: %d state: %d, max gap %d", tx.Sender, nonce, pending, maxGap)}
+	}
+	return nil
+}
@@ -0,0 +1,87 @@
+package handler
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"testing"
+
+	"github.com/48Club/service_agent/tools"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	ethtypes "github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/crypto"
+)
+
+// 测试使用 handler/config.json: 每个发送方 60 秒 limit 3 (计数需小于 limit, 即放行 2 笔), nonce 间隔最多 5, 0x2c75... 在白名单中
+
+// sendTxs 按顺序签名 nonces 对应的交易, 作为一个批量请求解析后检查发送方, 返回每笔交易的错误信息
+func sendTxs(t *testing.T, key *ecdsa.PrivateKey, nonces ...uint64) []string {
+	to := common.HexToAddress("0x48")
+	var reqs []map[string]any
+	for i, nonce := range nonces {
+		tx, err := ethtypes.SignNewTx(key, ethtypes.LatestSignerForChainID(big.NewInt(56)), &ethtypes.LegacyTx{Nonce: nonce, GasPrice: big.NewInt(1e9), Gas: 21000, To: &to})
+		if err != nil {
+			t.Fatal(err)
+		}
+		raw, _ := tx.MarshalBinary()
+		reqs = append(reqs, map[string]any{"jsonrpc": "2.0", "id": i, "method": "eth_sendRawTransaction", "params": []any{hexutil.Encode(raw)}})
+	}
+	body, _ := json.Marshal(reqs)
+	d := tools.DecodeRequestBody("rpc.48.club", "test", body)
+	checkSenders(context.Background(), "rpc.48.club", d)
+
+	errs := make([]string, len(nonces))
+	for i, r := range d.Responses {
+		if r != nil {
+			errs[i] = fmt.Sprint(r["error"])
+		}
+	}
+	return errs
+}
+
+func TestCheckSenders(t *testing.T) {
+	pending := map[common.Address]uint64{}
+	defer func(f func(context.Context, common.Address) (uint64, error)) { pendingNonceAt = f }(pendingNonceAt)
+	pendingNonceAt = func(_ context.Context, sender common.Address) (uint64, error) {
+		if n, ok := pending[sender]; ok {
+			return n, nil
+		}
+		return 0, errors.New("upstream down")
+	}
+
+	// 达到上限后拒绝
+	key, _ := crypto.GenerateKey()
+	pending[crypto.PubkeyToAddress(key.PublicKey)] = 0
+	errs := sendTxs(t, key, 0, 1, 2)
+	if errs[0] != "" || errs[1] != "" || errs[2] == "" {
+		t.Errorf("sender limit: got %q", errs)
+	}
+
+	// 白名单中的发送方不限速, 也不检查 nonce 间隔
+	allowed, _ := crypto.HexToECDSA("4c0883a69102937d6231471b5dbb6204fe5129617082792ae468d01a3f362318")
+	pending[crypto.PubkeyToAddress(allowed.PublicKey)] = 0
+	for _, err := range sendTxs(t, allowed, 0, 1, 2, 100) {
+		if err != "" {
+			t.Errorf("allowlisted sender: got %q", err)
+		}
+	}
+
+	// nonce 超出 pending nonce 太多时拒绝
+	key, _ = crypto.GenerateKey()
+	pending[crypto.PubkeyToAddress(key.PublicKey)] = 10
+	errs = sendTxs(t, key, 15, 16)
+	if errs[0] != "" || errs[1] == "" {
+		t.Errorf("nonce gap: got %q", errs)
+	}
+
+	// 查询 pending nonce 失败时放行
+	key, _ = crypto.GenerateKey()
+	errs = sendTxs(t, key, 100)
+	if errs[0] != "" {
+		t.Errorf("fail open: got %q", errs)
+	}
+}
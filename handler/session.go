@@ -0,0 +1,158 @@
+package handler
+
+import (
+	"bytes"
+	"encoding/json"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/48Club/service_agent/metrics"
+)
+
+var (
+	sessions      sync.Map // id => *wsSession
+	nextSessionId atomic.Uint64
+)
+
+// wsSession 记录一个 websocket 连接及其上的订阅, 用于管理 API 展示
+type wsSession struct {
+	id        uint64
+	ip        string
+	host      string
+	key       string
+	upstream  string
+	createdAt time.Time
+
+	mu            sync.Mutex
+	pending       map[string]string // eth_subscribe 请求 id => 订阅类型, 等待上游返回订阅 id
+	subscriptions map[string]string // 订阅 id => 订阅类型
+}
+
+type SessionInfo struct {
+	Id            uint64            `json:"id"`
+	IP            string            `json:"ip"`
+	Host          string            `json:"host"`
+	Key           string            `json:"key,omitempty"`
+	Upstream      string            `json:"upstream"`
+	CreatedAt     time.Time         `json:"created_at"`
+	Subscriptions map[string]string `json:"subscriptions"`
+}
+
+func newWsSession(ip, host, key, upstream string) *wsSession {
+	s := &wsSession{
+		id:            nextSessionId.Add(1),
+		ip:            ip,
+		host:          host,
+		key:           key,
+		upstream:      upstream,
+		createdAt:     time.Now(),
+		pending:       map[string]string{},
+		subscriptions: map[string]string{},
+	}
+	sessions.Store(s.id, s)
+	metrics.WebSocketConnections.WithLabelValues(hostLabel(host)).Inc()
+	return s
+}
+
+func (s *wsSession) close() {
+	sessions.Delete(s.id)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	label := hostLabel(s.host)
+	metrics.WebSocketConnections.WithLabelValues(label).Dec()
+	metrics.WebSocketSubscriptions.WithLabelValues(label).Sub(float64(len(s.subscriptions)))
+}
+
+type subscriptionMessage struct {
+	Id     json.RawMessage   `json:"id"`
+	Method string            `json:"method"`
+	Params []json.RawMessage `json:"params"`
+	Result json.RawMessage   `json:"result"`
+}
+
+func compactId(id json.RawMessage) string {
+	var b bytes.Buffer
+	if json.Compact(&b, id) != nil {
+		return string(id)
+	}
+	return b.String()
+}
+
+// trackRequest 记录客户端的 eth_subscribe / eth_unsubscribe 请求
+func (s *wsSession) trackRequest(method string, message []byte) {
+	if method != "eth_subscribe" && method != "eth_unsubscribe" {
+		return
+	}
+	var msg subscriptionMessage
+	if json.Unmarshal(message, &msg) != nil || len(msg.Params) == 0 {
+		return
+	}
+	var param string
+	if json.Unmarshal(msg.Params[0], &param) != nil {
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	switch method {
+	case "eth_subscribe":
+		s.pending[compactId(msg.Id)] = param
+	case "eth_unsubscribe":
+		if _, ok := s.subscriptions[param]; ok {
+			delete(s.subscriptions, param)
+			metrics.WebSocketSubscriptions.WithLabelValues(hostLabel(s.host)).Dec()
+		}
+	}
+}
+
+// trackResponse 从上游的响应中获取订阅 id, 只在有等待中的订阅请求时解析
+func (s *wsSession) trackResponse(message []byte) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if len(s.pending) == 0 {
+		return
+	}
+
+	var msg subscriptionMessage
+	if json.Unmarshal(message, &msg) != nil || msg.Id == nil {
+		return
+	}
+	id := compactId(msg.Id)
+	kind, ok := s.pending[id]
+	if !ok {
+		return
+	}
+	delete(s.pending, id)
+
+	var subId string
+	if json.Unmarshal(msg.Result, &subId) != nil {
+		return // 订阅失败
+	}
+	s.subscriptions[subId] = kind
+	metrics.WebSocketSubscriptions.WithLabelValues(hostLabel(s.host)).Inc()
+}
+
+func (s *wsSession) info() SessionInfo {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	subscriptions := make(map[string]string, len(s.subscriptions))
+	for k, v := range s.subscriptions {
+		subscriptions[k] = v
+	}
+	return SessionInfo{s.id, s.ip, s.host, s.key, s.upstream, s.createdAt, subscriptions}
+}
+
+// Sessions 当前所有 websocket 连接
+func Sessions() []SessionInfo {
+	infos := []SessionInfo{}
+	sessions.Range(func(_, v any) bool {
+		infos = append(infos, v.(*wsSession).info())
+		return true
+	})
+	sort.Slice(infos, func(i, j int) bool { return infos[i].Id < infos[j].Id })
+	return infos
+}
@@ -0,0 +1,72 @@
+package handler
+
+import (
+	"context"
+	"net/http"
+
+	"github.com/48Club/service_agent/tools"
+	"github.com/48Club/service_agent/tracing"
+	"github.com/48Club/service_agent/types"
+	"github.com/gin-gonic/gin"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// TracingMiddleware 为每个请求创建根 span, 后续中间件通过 c.Request.Context() 挂载子 span
+func TracingMiddleware(c *gin.Context) {
+	ctx, span := tracing.Tracer.Start(c.Request.Context(), c.Request.Method+" "+c.Request.URL.Path,
+		trace.WithSpanKind(trace.SpanKindServer),
+		trace.WithAttributes(
+			attribute.String("http.request.method", c.Request.Method),
+			attribute.String("server.address", c.Request.Host),
+			attribute.String("url.path", c.Request.URL.Path),
+		),
+	)
+	defer span.End()
+
+	c.Request = c.Request.WithContext(ctx)
+	c.Next()
+
+	statusCode := c.Writer.Status()
+	span.SetAttributes(
+		attribute.Int("http.response.status_code", statusCode),
+		attribute.String("client.address", c.GetString("ip")),
+		attribute.StringSlice("rpc.methods", c.GetStringSlice("rpc_methods")),
+		attribute.String("upstream", c.GetString("upstream")),
+	)
+	if statusCode >= http.StatusInternalServerError {
+		span.SetStatus(codes.Error, http.StatusText(statusCode))
+	}
+}
+
+// tracedLimit 在 span 中执行限速判断
+func tracedLimit(ctx context.Context, ip string, pass bool, count int, res *types.LimitResponse, hostname, profile string) bool {
+	_, span := tracing.Tracer.Start(ctx, "limiter")
+	defer span.End()
+
+	tooManyRequests, _ := LimitMiddleware(ip, pass, count, res, hostname, profile)
+	span.SetAttributes(
+		attribute.String("limiter.profile", profile),
+		attribute.Int("limiter.count", count),
+		attribute.Bool("limiter.pass", pass),
+		attribute.Bool("limiter.rejected", tooManyRequests),
+	)
+	return tooManyRequests
+}
+
+// tracedDecodeRequestBody 在 span 中解析请求体
+func tracedDecodeRequestBody(ctx context.Context, host, caller string, body []byte) *tools.Decoded {
+	_, span := tracing.Tracer.Start(ctx, "DecodeRequestBody")
+	defer span.End()
+
+	d := tools.DecodeRequestBody(host, caller, body)
+	span.SetAttributes(
+		attribute.StringSlice("rpc.methods", d.Methods),
+		attribute.Int("rpc.batch_count", d.BatchCount),
+		attribute.Bool("rpc.skip_limit", d.SkipLimit),
+		attribute.Bool("rpc.agent_response", d.Local()),
+		attribute.Bool("rpc.partial_agent_response", d.Partial()),
+	)
+	return d
+}
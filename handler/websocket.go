@@ -2,13 +2,18 @@ package handler
 
 import (
 	"context"
+	"encoding/json"
 	"log"
 	"net/http"
 	"sync"
 
-	"github.com/48Club/service_agent/tools"
+	"github.com/48Club/service_agent/tracing"
+	"github.com/48Club/service_agent/upstream"
 	"github.com/gin-gonic/gin"
 	"github.com/gorilla/websocket"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/propagation"
 )
 
 var upgrader = websocket.Upgrader{
@@ -19,7 +24,7 @@ var upgrader = websocket.Upgrader{
 	},
 }
 
-func handleWebSocket(c *gin.Context, toHost string) {
+func handleWebSocket(c *gin.Context, up *upstream.Upstream) {
 	ctx, cancelCtx := context.WithCancel(c.Request.Context())
 	defer cancelCtx()
 
@@ -31,10 +36,21 @@ func handleWebSocket(c *gin.Context, toHost string) {
 	}
 	defer conn.Close()
 
-	proxyConn, _, err := websocket.DefaultDialer.DialContext(ctx, toHost, http.Header{
+	// 客户端连接同时由两个 goroutine 写入, gorilla/websocket 要求串行
+	var writeMu sync.Mutex
+	writeClient := func(messageType int, data []byte) error {
+		writeMu.Lock()
+		defer writeMu.Unlock()
+		return conn.WriteMessage(messageType, data)
+	}
+
+	header := http.Header{
 		"Origin": {c.Request.Header.Get("Origin")},
 		"Host":   {c.Request.Host},
-	})
+	}
+	otel.GetTextMapPropagator().Inject(ctx, propagation.HeaderCarrier(header))
+
+	proxyConn, _, err := websocket.DefaultDialer.DialContext(ctx, up.WS, header)
 
 	if err != nil {
 		log.Println("Failed to connect to target server:", err)
@@ -51,7 +67,88 @@ func handleWebSocket(c *gin.Context, toHost string) {
 		wg.Done()
 	}
 
-	ip, host := c.GetString("ip"), c.Request.Host
+	ip, host, profile := c.GetString("ip"), c.Request.Host, c.GetString("profile")
+
+	session := newWsSession(ip, host, c.GetString("key"), c.GetString("upstream"))
+	defer session.close()
+
+	// handleClientMessage 处理客户端发来的一条消息, 返回 false 时关闭连接
+	handleClientMessage := func(messageType int, message []byte) bool {
+		ctx, span := tracing.Tracer.Start(ctx, "websocket.message")
+		defer span.End()
+
+		if tracedLimit(ctx, ip, true, 1, nil, host, profile) {
+			penalize(ip, session.key)
+			_ = writeClient(websocket.CloseMessage, websocket.FormatCloseMessage(websocket.ClosePolicyViolation, "Too many requests"))
+			return false
+		}
+
+		if messageType == websocket.TextMessage {
+			d := tracedDecodeRequestBody(ctx, host, caller(session.key, ip), message)
+
+			if len(d.Methods) == 1 && !d.Batch {
+				session.trackRequest(d.Methods[0], message)
+			}
+
+			if !d.SkipLimit {
+				// 统计限速
+				if d.BatchCount > 0 {
+					// 统计批量请求中非 eth_sendRawTransaction 的请求数量
+					if addLimitBatchReq(ctx, ip, d.BatchCount, host, profile) {
+						releaseTxs(d)
+						penalize(ip, session.key)
+						_ = writeClient(websocket.CloseMessage, websocket.FormatCloseMessage(websocket.ClosePolicyViolation, "Too many requests"))
+						return false
+					}
+				}
+			}
+
+			applyGasPolicy(ctx, host, d)
+			splitLogs(ctx, host, d)
+			checkSenders(ctx, host, d)
+			broadcastTxs(ctx, host, d)
+			releaseRejected(d)
+			recordTxs(session.key, d)
+			forwardBundles(ctx, host, ip, session.key, d)
+			var resp any
+			switch {
+			case d.Local():
+				// 由 agent 生成响应
+				span.SetAttributes(attribute.Bool("rpc.agent_response", true))
+				resp = d.Response()
+			case d.Pinned > up.Health().Head:
+				// 连接的上游落后于改写后的区块号, 通过 HTTP 发给已达到该高度的上游
+				var err error
+				if resp, err = postPinned(ctx, host, d); err != nil {
+					log.Println("Pinned request to target server:", err)
+					return false
+				}
+			case d.Partial():
+				// 需要合并的批量请求通过 HTTP 发给上游
+				batch, err := postUpstream(ctx, up, host, d.Forward())
+				if err != nil {
+					log.Println("Batch request to target server:", err)
+					return false
+				}
+				resp = d.Merge(batch)
+			}
+			if resp != nil {
+				data, _ := json.Marshal(resp)
+				if err := writeClient(websocket.TextMessage, data); err != nil {
+					log.Println("Write error to client:", err)
+					return false
+				}
+				return true
+			}
+			message = d.Forward()
+		}
+
+		if err := proxyConn.WriteMessage(messageType, message); err != nil {
+			log.Println("Write error to target server:", err)
+			return false
+		}
+		return true
+	}
 
 	go func() {
 		defer cancelConn(proxyConn)
@@ -65,39 +162,7 @@ func handleWebSocket(c *gin.Context, toHost string) {
 					log.Println("Read error from client:", err)
 					return
 				}
-				tooManyRequests, _ := LimitMiddleware(ip, true, 1, nil, host)
-				if tooManyRequests {
-					_ = conn.WriteMessage(websocket.CloseMessage, websocket.FormatCloseMessage(websocket.ClosePolicyViolation, "Too many requests"))
-					return
-				}
-
-				if messageType == websocket.TextMessage {
-					resp, buildRespByAgent, batchCount, sikpLimit := tools.DecodeRequestBody(host, message)
-
-					if !sikpLimit {
-						// 统计限速
-						if batchCount > 0 {
-							// 统计批量请求中非 eth_sendRawTransaction 的请求数量
-							if addLimitBatchReq(ip, batchCount, host) {
-								_ = conn.WriteMessage(websocket.CloseMessage, websocket.FormatCloseMessage(websocket.ClosePolicyViolation, "Too many requests"))
-								return
-							}
-						}
-					}
-
-					if buildRespByAgent {
-						// 由 agent 生成响应
-						if err := conn.WriteJSON(resp); err != nil {
-							log.Println("Write error to client:", err)
-							return
-						}
-						continue
-					}
-
-				}
-
-				if err := proxyConn.WriteMessage(messageType, message); err != nil {
-					log.Println("Write error to target server:", err)
+				if !handleClientMessage(messageType, message) {
 					return
 				}
 			}
@@ -116,7 +181,10 @@ func handleWebSocket(c *gin.Context, toHost string) {
 					log.Println("Read error from target server:", err)
 					return
 				}
-				if err := conn.WriteMessage(messageType, message); err != nil {
+				if messageType == websocket.TextMessage {
+					session.trackResponse(message)
+				}
+				if err := writeClient(messageType, message); err != nil {
 					log.Println("Write error to client:", err)
 					return
 				}
@@ -36,6 +36,15 @@ func (iprls IPBasedRateLimiters) Prune(ip string) {
 	}
 }
 
+// Reset 清空所有 key 的计数
+func (iprls IPBasedRateLimiters) Reset() {
+	for _, rl := range iprls {
+		rl.mu.Lock()
+		rl.limiters = make(map[string]*FixedWindowRateLimiter)
+		rl.mu.Unlock()
+	}
+}
+
 func init() {
 	Limits = IPBasedRateLimiters{
 		NewIPBasedRateLimiter(80, time.Second*5), // [9.6|16]qps
@@ -137,6 +146,40 @@ func (iprls IPBasedRateLimiters) AllowPassCheck(ip string) {
 	}
 }
 
+// Usage 某个 key 在当前窗口内的用量
+type Usage struct {
+	Key     string    `json:"key"`
+	Used    int       `json:"used"`
+	Limit   int       `json:"limit"`
+	Window  string    `json:"window"`
+	ResetAt time.Time `json:"reset_at"`
+}
+
+// Snapshot 返回当前窗口内有用量的 key
+func (iprl *IPBasedRateLimiter) Snapshot() []Usage {
+	iprl.mu.Lock()
+	defer iprl.mu.Unlock()
+
+	now := time.Now()
+	usages := []Usage{}
+	for key, rl := range iprl.limiters {
+		rl.mu.Lock()
+		if rl.count > 0 && now.Sub(rl.lastReset) < rl.window {
+			usages = append(usages, Usage{key, rl.count, rl.limit, rl.window2, rl.lastReset.Add(rl.window)})
+		}
+		rl.mu.Unlock()
+	}
+	return usages
+}
+
+func (iprls IPBasedRateLimiters) Snapshot() []Usage {
+	usages := []Usage{}
+	for _, rl := range iprls {
+		usages = append(usages, rl.Snapshot()...)
+	}
+	return usages
+}
+
 type IsAllow struct {
 	Allow bool
 	Used  int
@@ -0,0 +1,22 @@
+package limit
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSnapshot(t *testing.T) {
+	rls := IPBasedRateLimiters{NewIPBasedRateLimiter(10, time.Minute)}
+	assert.False(t, rls.Allow("1.1.1.1", false, 3, nil))
+	assert.False(t, rls.Allow("2.2.2.2", true, 1, nil)) // pass 不计数
+
+	usages := rls.Snapshot()
+	assert.Len(t, usages, 1)
+	assert.Equal(t, "1.1.1.1", usages[0].Key)
+	assert.Equal(t, 3, usages[0].Used)
+
+	rls.Prune("1.1.1.1")
+	assert.Empty(t, rls.Snapshot())
+}
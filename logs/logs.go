@@ -0,0 +1,186 @@
+package logs
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"maps"
+	"strings"
+	"sync"
+
+	"github.com/48Club/service_agent/types"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+)
+
+// Limits eth_getLogs 的查询限制, 为 0 表示不限制
+type Limits struct {
+	MaxRange     uint64 // 区块范围
+	MaxAddresses int
+	MaxTopics    int    // 所有位置的 topic 数量之和
+	ChunkSize    uint64 // 大于 0 时, 超过该区块数的查询由 agent 拆分后并发执行
+	Concurrency  int    // 拆分后的并发数, 默认 4
+}
+
+// MaxChunks 单个查询最多拆分的次数
+const MaxChunks = 1000
+
+// ErrTooLarge 拆分查询的结果总大小超过限制
+var ErrTooLarge = errors.New("query returned too many logs, narrow the block range")
+
+func limitError(format string, a ...any) *types.Web3Error {
+	return &types.Web3Error{Code: -32005, Message: fmt.Sprintf(format, a...)}
+}
+
+// Query 需要拆分执行的查询, 区块范围为 [From, To]
+type Query struct {
+	From, To uint64
+	filter   map[string]any
+}
+
+// Check 检查查询是否超过限制, 需要拆分时返回 Query
+// head 为上游的最新区块, 用于解析 latest 等标签, 为 0 时不检查区块范围
+// 无法解析的参数交给上游返回错误
+func (l *Limits) Check(params []any, head uint64) (*Query, *types.Web3Error) {
+	if len(params) != 1 {
+		return nil, nil
+	}
+	filter, ok := params[0].(map[string]any)
+	if !ok {
+		return nil, nil
+	}
+
+	if n := count(filter["address"]); l.MaxAddresses > 0 && n > l.MaxAddresses {
+		return nil, limitError("too many addresses: %d, limit %d", n, l.MaxAddresses)
+	}
+	if topics, ok := filter["topics"].([]any); ok && l.MaxTopics > 0 {
+		n := 0
+		for _, t := range topics {
+			n += count(t)
+		}
+		if n > l.MaxTopics {
+			return nil, limitError("too many topics: %d, limit %d", n, l.MaxTopics)
+		}
+	}
+
+	if _, ok := filter["blockHash"]; ok || head == 0 {
+		return nil, nil
+	}
+	from, ok1 := resolve(filter["fromBlock"], head)
+	to, ok2 := resolve(filter["toBlock"], head)
+	if !ok1 || !ok2 || from > to {
+		return nil, nil
+	}
+	n := to - from + 1
+	if l.MaxRange > 0 && n > l.MaxRange {
+		return nil, limitError("block range too large: %d blocks, limit %d", n, l.MaxRange)
+	}
+	if l.ChunkSize > 0 && n > l.ChunkSize {
+		if chunks := (n-1)/l.ChunkSize + 1; chunks > MaxChunks {
+			return nil, limitError("block range too large: %d blocks, limit %d", n, l.ChunkSize*MaxChunks)
+		}
+		return &Query{From: from, To: to, filter: filter}, nil
+	}
+	return nil, nil
+}
+
+// count 单个值为 1, 数组为元素个数, null 为 0
+func count(v any) int {
+	switch v := v.(type) {
+	case nil:
+		return 0
+	case []any:
+		return len(v)
+	}
+	return 1
+}
+
+// resolve 解析区块号, 未指定时为 latest, safe 和 finalized 按 latest 计算以免低估范围
+func resolve(v any, head uint64) (uint64, bool) {
+	s, ok := v.(string)
+	if v == nil {
+		s, ok = "latest", true
+	}
+	if !ok {
+		return 0, false
+	}
+	switch strings.ToLower(s) {
+	case "earliest":
+		return 0, true
+	case "latest", "pending", "safe", "finalized":
+		return head, true
+	}
+	n, err := hexutil.DecodeUint64(s)
+	return n, err == nil
+}
+
+// Chunks 按 size 拆分后的过滤条件, 其他字段保持不变
+func (q *Query) Chunks(size uint64) []map[string]any {
+	var chunks []map[string]any
+	for from := q.From; from <= q.To; from += size {
+		to := min(from+size-1, q.To)
+		f := maps.Clone(q.filter)
+		f["fromBlock"], f["toBlock"] = hexutil.EncodeUint64(from), hexutil.EncodeUint64(to)
+		chunks = append(chunks, f)
+		if to == q.To {
+			break
+		}
+	}
+	return chunks
+}
+
+// Run 以 concurrency 的并发执行拆分后的查询, 按区块顺序拼接结果
+// 结果总大小超过 maxBytes 时返回 ErrTooLarge, 任一查询失败时取消其余查询
+func (q *Query) Run(ctx context.Context, size uint64, concurrency int, maxBytes int64, call func(context.Context, map[string]any) (json.RawMessage, error)) (json.RawMessage, error) {
+	chunks := q.Chunks(size)
+	results := make([][]json.RawMessage, len(chunks))
+	if concurrency <= 0 {
+		concurrency = 4
+	}
+
+	ctx, cancel := context.WithCancelCause(ctx)
+	defer cancel(nil)
+	var (
+		wg    sync.WaitGroup
+		mu    sync.Mutex
+		total int64
+	)
+	sem := make(chan struct{}, concurrency)
+	for i, f := range chunks {
+		select {
+		case sem <- struct{}{}:
+		case <-ctx.Done():
+		}
+		if ctx.Err() != nil {
+			break
+		}
+		wg.Go(func() {
+			defer func() { <-sem }()
+			raw, err := call(ctx, f)
+			if err == nil {
+				mu.Lock()
+				total += int64(len(raw))
+				if total > maxBytes {
+					err = ErrTooLarge
+				}
+				mu.Unlock()
+			}
+			if err == nil {
+				err = json.Unmarshal(raw, &results[i])
+			}
+			if err != nil {
+				cancel(err)
+			}
+		})
+	}
+	wg.Wait()
+	if err := context.Cause(ctx); err != nil {
+		return nil, err
+	}
+
+	merged := []json.RawMessage{}
+	for _, r := range results {
+		merged = append(merged, r...)
+	}
+	return json.Marshal(merged)
+}
@@ -0,0 +1,79 @@
+package logs
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/common/hexutil"
+)
+
+func TestCheck(t *testing.T) {
+	l := &Limits{MaxRange: 1000, MaxAddresses: 2, MaxTopics: 3, ChunkSize: 100}
+	for _, c := range []struct {
+		name    string
+		filter  map[string]any
+		split   bool
+		wantErr bool
+	}{
+		{"default latest", map[string]any{}, false, false},
+		{"small range", map[string]any{"fromBlock": "0x1", "toBlock": "0x64"}, false, false},
+		{"split", map[string]any{"fromBlock": "0x1", "toBlock": "0x65"}, true, false},
+		{"latest tag", map[string]any{"fromBlock": hexutil.EncodeUint64(10000 - 500)}, true, false},
+		{"too wide", map[string]any{"fromBlock": "earliest"}, false, true},
+		{"block hash", map[string]any{"blockHash": "0x01", "fromBlock": "earliest"}, false, false},
+		{"addresses", map[string]any{"address": []any{"0x1", "0x2", "0x3"}}, false, true},
+		{"topics", map[string]any{"topics": []any{"0x1", nil, []any{"0x2", "0x3", "0x4"}}}, false, true},
+		{"bad block", map[string]any{"fromBlock": "bad"}, false, false},
+	} {
+		q, err := l.Check([]any{c.filter}, 10000)
+		if (err != nil) != c.wantErr || (q != nil) != c.split {
+			t.Errorf("%s: got %v, %v", c.name, q, err)
+		}
+	}
+
+	// 未限制区块范围时仍然限制拆分次数
+	l = &Limits{ChunkSize: 1}
+	if _, err := l.Check([]any{map[string]any{"fromBlock": "earliest"}}, 10000); err == nil {
+		t.Error("want error for too many chunks")
+	}
+}
+
+func TestRun(t *testing.T) {
+	q := &Query{From: 10, To: 34, filter: map[string]any{"address": "0x48"}}
+	if n := len(q.Chunks(10)); n != 3 {
+		t.Fatalf("got %d chunks", n)
+	}
+
+	// 每个区块返回一条日志, 结果应按区块顺序拼接
+	call := func(_ context.Context, f map[string]any) (json.RawMessage, error) {
+		from, _ := hexutil.DecodeUint64(f["fromBlock"].(string))
+		to, _ := hexutil.DecodeUint64(f["toBlock"].(string))
+		var logs []uint64
+		for n := from; n <= to; n++ {
+			logs = append(logs, n)
+		}
+		return json.Marshal(logs)
+	}
+	raw, err := q.Run(context.Background(), 10, 2, 1<<20, call)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got []uint64
+	json.Unmarshal(raw, &got)
+	if len(got) != 25 || got[0] != 10 || got[24] != 34 {
+		t.Fatalf("got %v", got)
+	}
+
+	if _, err := q.Run(context.Background(), 10, 2, 20, call); !errors.Is(err, ErrTooLarge) {
+		t.Fatalf("got %v, want ErrTooLarge", err)
+	}
+	failing := func(ctx context.Context, f map[string]any) (json.RawMessage, error) {
+		return nil, fmt.Errorf("upstream down")
+	}
+	if _, err := q.Run(context.Background(), 10, 2, 1<<20, failing); err == nil {
+		t.Fatal("want error")
+	}
+}
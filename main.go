@@ -2,61 +2,212 @@ package main
 
 import (
 	"context"
+	"crypto/tls"
 	"log"
+	"net"
 	"net/http"
+	"net/netip"
 	"os"
 	"os/signal"
 	"syscall"
 	"time"
 
+	"github.com/48Club/service_agent/acl"
+	"github.com/48Club/service_agent/admin"
+	"github.com/48Club/service_agent/broadcast"
 	"github.com/48Club/service_agent/config"
 	"github.com/48Club/service_agent/handler"
-	"github.com/gin-contrib/cors"
+	"github.com/48Club/service_agent/receipts"
+	"github.com/48Club/service_agent/server"
+	"github.com/48Club/service_agent/tracing"
+	"github.com/48Club/service_agent/txcache"
 	"github.com/gin-gonic/gin"
+	"github.com/quic-go/quic-go/http3"
 )
 
 func main() {
+	tc := config.Get().Tracing
+	shutdownTracing, err := tracing.Init(context.Background(), tc.Exporter, tc.Endpoint, tc.Insecure, tc.SampleRatio)
+	if err != nil {
+		log.Fatalf("tracing: %s\n", err)
+	}
+
+	pollCtx, stopPolling := context.WithCancel(context.Background())
+	defer stopPolling()
+	config.Get().UpstreamPool.Start(pollCtx)
+	acl.Start(pollCtx, 10*time.Second)
+	txcache.Start(pollCtx, 10*time.Second)
+	if rc := config.Get().Receipts; rc.DB != "" {
+		receipts.Txs, err = receipts.Open(rc.DB, rc.DropAfter*time.Second, rc.Retention*time.Second)
+		if err != nil {
+			log.Fatalf("receipts: %s\n", err)
+		}
+		receipts.Txs.Start(pollCtx, rc.Interval*time.Second, func(ctx context.Context) (receipts.Chain, error) {
+			return config.Get().UpstreamPool.Client(ctx)
+		}, func(key string) (string, string) {
+			if l, ok := config.Get().ExceptionLimiterMap[key]; ok {
+				return l.Webhook, l.WebhookSecret
+			}
+			return "", ""
+		})
+	}
+	broadcast.PrivateTxs.Start(pollCtx, time.Second, func(ctx context.Context) (broadcast.Chain, error) {
+		return config.Get().UpstreamPool.Client(ctx)
+	}, func() *broadcast.Broadcaster {
+		return config.Get().Broadcaster
+	})
+
 	r := gin.New()
-	r.Use(handler.CustomLoggerMiddleware, gin.Recovery())
+	r.Use(handler.TracingMiddleware, handler.MetricsMiddleware, handler.CustomLoggerMiddleware, gin.Recovery())
 	r.TrustedPlatform = gin.PlatformCloudflare
-	if config.GlobalConfig.CDNPlatforms != "" {
-		r.TrustedPlatform = config.GlobalConfig.CDNPlatforms
+	if config.Get().CDNPlatforms != "" {
+		r.TrustedPlatform = config.Get().CDNPlatforms
 	}
 
-	r.Use(cors.New(
-		cors.Config{
-			AllowOrigins: []string{"*"},
-			AllowMethods: []string{"GET", "POST", "PUT", "DELETE", "OPTIONS"},
-			AllowHeaders: []string{"Accept", "Authorization", "Cache-Control", "Content-Type", "DNT", "If-Modified-Since", "Keep-Alive", "Origin", "User-Agent", "X-Requested-With"},
-		},
-	), handler.CheckHeader, handler.SetMaxRequestBodySize, handler.CheckIPMiddleware, handler.CustomRecoveryMiddleware)
+	r.Use(handler.CheckACLMiddleware, handler.CORSMiddleware, handler.CheckHeader, handler.CheckRulesMiddleware, handler.SetMaxRequestBodySize, handler.CheckIPMiddleware, handler.CustomRecoveryMiddleware)
 
 	r.NoRoute(handler.AnyHandler)
 	r.NoMethod(handler.AnyHandler)
 
-	srv := &http.Server{
-		Addr:    ":80",
-		Handler: r,
+	servers := []*http.Server{}
+	for _, l := range config.Get().Listeners {
+		srv := &http.Server{
+			Addr:        l.Address,
+			Handler:     r,
+			ConnContext: server.ConnContext,
+			BaseContext: l.BaseContext,
+		}
+		if l.H2C {
+			// WebSocket 仍需通过 HTTP/1.1 升级
+			srv.Protocols = new(http.Protocols)
+			srv.Protocols.SetHTTP1(true)
+			srv.Protocols.SetUnencryptedHTTP2(true)
+		}
+		lns, err := l.Listen()
+		if err != nil {
+			log.Fatalf("listen %s: %s\n", l, err)
+		}
+		for _, ln := range lns {
+			if l.Network != "unix" {
+				ln = proxied(ln)
+			}
+			go serve(srv, ln)
+		}
+		servers = append(servers, srv)
+	}
+
+	var h3Srv *http3.Server
+	if tc := config.Get().TLS; tc.Listen != "" {
+		store, err := newCertStore(tc)
+		if err != nil {
+			log.Fatalf("tls: %s\n", err)
+		}
+		go store.Watch(pollCtx, tc.Watch*time.Second)
+		tlsSrv := &http.Server{
+			Addr:        tc.Listen,
+			Handler:     r,
+			ConnContext: server.ConnContext,
+			BaseContext: tc.Listener.BaseContext,
+			TLSConfig:   store.TLSConfig(),
+		}
+		if tc.HTTP3 {
+			h3Srv = server.NewHTTP3(tc.Listener, store, r)
+			tlsSrv.Handler = server.AltSvc(h3Srv, r)
+			go func() {
+				if err := h3Srv.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+					log.Fatalf("listen http3: %s\n", err)
+				}
+			}()
+		}
+		ln, err := net.Listen("tcp", tlsSrv.Addr)
+		if err != nil {
+			log.Fatalf("listen: %s\n", err)
+		}
+		go serve(tlsSrv, proxied(ln))
+		servers = append(servers, tlsSrv)
 	}
 
+	adminSrv := &http.Server{
+		Addr:    config.Get().AdminListen,
+		Handler: admin.Router(),
+	}
 	go func() {
-		if err := srv.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+		if err := adminSrv.ListenAndServe(); err != nil && err != http.ErrServerClosed {
 			log.Fatalf("listen: %s\n", err)
 		}
 	}()
 
 	sig := make(chan os.Signal, 1)
-	signal.Notify(sig, syscall.SIGINT, syscall.SIGTERM)
+	signal.Notify(sig, syscall.SIGINT, syscall.SIGTERM, syscall.SIGHUP)
 
-	s := <-sig
-	log.Printf("Signal (%v) received, stopping\n", s)
+	for s := range sig {
+		if s == syscall.SIGHUP {
+			// 重新读取配置, 包括 CDN IP 段文件
+			if err := config.Reload(); err != nil {
+				log.Printf("config reload failed: %v", err)
+			}
+			continue
+		}
+		log.Printf("Signal (%v) received, stopping\n", s)
+		break
+	}
 
+	stopPolling()
 	ctx, cancel := context.WithTimeout(context.Background(), time.Second*20)
 	defer cancel()
 
-	if err := srv.Shutdown(ctx); err != nil {
-		log.Printf("server shutdown failed:%+v", err)
+	for _, s := range servers {
+		if err := s.Shutdown(ctx); err != nil {
+			log.Printf("server %s shutdown failed:%+v", s.Addr, err)
+		}
+	}
+	if h3Srv != nil {
+		if err := h3Srv.Shutdown(ctx); err != nil {
+			log.Printf("http3 server shutdown failed:%+v", err)
+		}
+	}
+	if err := adminSrv.Shutdown(ctx); err != nil {
+		log.Printf("admin server shutdown failed:%+v", err)
+	}
+	if err := shutdownTracing(ctx); err != nil {
+		log.Printf("tracing shutdown failed:%+v", err)
 	}
 
 	log.Print("server exited properly")
 }
+
+// proxied 配置了 proxy_protocol 时解析 PROXY 头
+func proxied(ln net.Listener) net.Listener {
+	if pp := config.Get().ProxyProtocol; len(pp.TrustedCIDRs) > 0 {
+		// 受信任的 IP 段随配置重载更新
+		ln = server.ProxyListener(ln, func(addr netip.Addr) bool {
+			trusted := config.Get().ProxyProtocol.Trusted
+			return trusted != nil && trusted.Contains(addr)
+		}, pp.Required, pp.Timeout*time.Second)
+	}
+	return ln
+}
+
+func serve(srv *http.Server, ln net.Listener) {
+	var err error
+	if srv.TLSConfig != nil {
+		err = srv.ServeTLS(ln, "", "")
+	} else {
+		err = srv.Serve(ln)
+	}
+	if err != nil && err != http.ErrServerClosed {
+		log.Fatalf("listen: %s\n", err)
+	}
+}
+
+func newCertStore(tc config.TLS) (*server.CertStore, error) {
+	minVersion, err := server.ParseVersion(tc.MinVersion)
+	if err != nil {
+		return nil, err
+	}
+	suites, err := server.ParseCipherSuites(tc.CipherSuites)
+	if err != nil {
+		return nil, err
+	}
+	return server.NewCertStore(&tls.Config{MinVersion: minVersion, CipherSuites: suites}, tc.Sites())
+}
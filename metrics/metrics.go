@@ -0,0 +1,126 @@
+package metrics
+
+import (
+	"strings"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+const namespace = "service_agent"
+
+var (
+	// 请求计数, upstream 为空表示请求未到达上游
+	Requests = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "http_requests_total",
+		Help:      "HTTP requests handled, by host, method, status and upstream.",
+	}, []string{"host", "method", "status", "upstream"})
+
+	RPCDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "rpc_request_duration_seconds",
+		Help:      "JSON-RPC request latency, by host and method. Batches are labelled \"batch\".",
+		Buckets:   []float64{.005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10, 30, 60},
+	}, []string{"host", "rpc_method"})
+
+	RateLimitRejections = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "ratelimit_rejections_total",
+		Help:      "Requests rejected by the rate limiter, by host and limiter profile.",
+	}, []string{"host", "profile"})
+
+	PenaltyBans = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "penalty_bans_total",
+		Help:      "Automatic bans of repeat rate-limit offenders, by kind (ip or key).",
+	}, []string{"kind"})
+
+	HeaderRuleMatches = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "header_rule_matches_total",
+		Help:      "Requests matched by a header rule, by host, rule name and action.",
+	}, []string{"host", "rule", "action"})
+
+	// result 为 accepted, rejected, timeout 或 error
+	BroadcastResults = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "broadcast_results_total",
+		Help:      "Raw transaction broadcasts, by endpoint and result.",
+	}, []string{"endpoint", "result"})
+
+	// result 同 BroadcastResults
+	BundleResults = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "bundle_results_total",
+		Help:      "Bundle requests forwarded to builders, by builder, method and result.",
+	}, []string{"builder", "method", "result"})
+
+	WebSocketConnections = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "websocket_connections",
+		Help:      "Active proxied WebSocket connections.",
+	}, []string{"host"})
+
+	WebSocketSubscriptions = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "websocket_subscriptions",
+		Help:      "Active eth_subscribe subscriptions on proxied WebSocket connections.",
+	}, []string{"host"})
+
+	UpstreamUp = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "upstream_up",
+		Help:      "Whether the upstream passed its last health check (1) or not (0).",
+	}, []string{"upstream"})
+
+	UpstreamHeadBlock = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "upstream_head_block",
+		Help:      "Upstream head block number at its last health check.",
+	}, []string{"upstream"})
+
+	UpstreamHeadLag = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "upstream_head_lag_seconds",
+		Help:      "Age of the upstream head block at its last health check.",
+	}, []string{"upstream"})
+
+	// result 为 hit 或 miss, 命中率 = hit / (hit + miss)
+	CacheLookups = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "cache_lookups_total",
+		Help:      "Cache lookups, by cache name and result (hit or miss).",
+	}, []string{"cache", "result"})
+)
+
+var rpcNamespaces = []string{"eth_", "net_", "web3_", "debug_", "trace_", "txpool_", "parlia_", "admin_"}
+
+// MethodLabel 限制 rpc_method 标签的取值范围, 避免客户端随意构造方法名导致标签基数爆炸
+func MethodLabel(method string) string {
+	if method == "batch" {
+		return method
+	}
+	if len(method) > 64 {
+		return "other"
+	}
+	for _, r := range method {
+		if !(r == '_' || r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9') {
+			return "other"
+		}
+	}
+	for _, ns := range rpcNamespaces {
+		if strings.HasPrefix(method, ns) {
+			return method
+		}
+	}
+	return "other"
+}
+
+func CacheHit(cache string, hit bool) {
+	if hit {
+		CacheLookups.WithLabelValues(cache, "hit").Inc()
+		return
+	}
+	CacheLookups.WithLabelValues(cache, "miss").Inc()
+}
@@ -0,0 +1,15 @@
+package metrics
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMethodLabel(t *testing.T) {
+	assert.Equal(t, "eth_call", MethodLabel("eth_call"))
+	assert.Equal(t, "batch", MethodLabel("batch"))
+	assert.Equal(t, "other", MethodLabel("foo_bar"))
+	assert.Equal(t, "other", MethodLabel("eth_call\n"))
+	assert.Equal(t, "other", MethodLabel(""))
+}
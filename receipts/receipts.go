@@ -0,0 +1,290 @@
+package receipts
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"math/big"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/ethereum/go-ethereum"
+	"github.com/ethereum/go-ethereum/common"
+	ethtypes "github.com/ethereum/go-ethereum/core/types"
+	"github.com/glebarez/sqlite"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+	"gorm.io/gorm/logger"
+)
+
+// 交易状态
+const (
+	StatusPending  = "pending"  // 尚未上链
+	StatusIncluded = "included" // 已上链, 回执中的执行结果见 Success
+	StatusReplaced = "replaced" // 同一 nonce 的其他交易已上链
+	StatusDropped  = "dropped"  // 不在交易池中且超过 drop_after 未上链
+)
+
+const (
+	maxNotifyAttempts = 5               // webhook 失败后在之后的轮询中重试, 超过次数后放弃
+	queueSize         = 4096            // 等待写入的记录数, 超过后丢弃
+	callTimeout       = 5 * time.Second // 每次查询上游的超时
+)
+
+// Submission 经 agent 提交的交易
+type Submission struct {
+	Hash           string    `gorm:"primaryKey;size:66" json:"hash"`
+	APIKey         string    `gorm:"index" json:"-"` // 提交时使用的 API key, 用于选择 webhook
+	Sender         string    `json:"from"`
+	Nonce          uint64    `json:"nonce"`
+	Status         string    `gorm:"index" json:"status"`
+	BlockNumber    uint64    `json:"block_number,omitempty"`
+	BlockHash      string    `json:"block_hash,omitempty"`
+	Success        bool      `json:"success"`
+	GasUsed        uint64    `json:"gas_used,omitempty"`
+	Notified       bool      `json:"-"`
+	NotifyAttempts int       `json:"-"`
+	CreatedAt      time.Time `json:"submitted_at"`
+	UpdatedAt      time.Time `json:"updated_at"`
+}
+
+// Chain 查询链上状态, 由上游节点的 ethclient 实现
+type Chain interface {
+	TransactionReceipt(ctx context.Context, hash common.Hash) (*ethtypes.Receipt, error)
+	TransactionByHash(ctx context.Context, hash common.Hash) (*ethtypes.Transaction, bool, error)
+	NonceAt(ctx context.Context, account common.Address, blockNumber *big.Int) (uint64, error)
+}
+
+// Webhook API key 的回调地址与签名密钥, url 为空表示不回调
+type Webhook func(key string) (url, secret string)
+
+// Tracker 记录提交的交易并轮询上链情况, 状态保存在 sqlite 中, 重启后继续跟踪
+type Tracker struct {
+	db        *gorm.DB
+	dropAfter time.Duration
+	retention time.Duration
+	client    *http.Client
+	queue     chan *Submission
+}
+
+var Txs *Tracker // 未开启时为 nil
+
+func Open(path string, dropAfter, retention time.Duration) (*Tracker, error) {
+	db, err := gorm.Open(sqlite.Open(path), &gorm.Config{Logger: logger.Default.LogMode(logger.Silent)})
+	if err != nil {
+		return nil, fmt.Errorf("receipts: %w", err)
+	}
+	if err := db.AutoMigrate(&Submission{}); err != nil {
+		return nil, fmt.Errorf("receipts: %w", err)
+	}
+	// sqlite 同一时间只允许一个写入
+	if sqlDB, err := db.DB(); err == nil {
+		sqlDB.SetMaxOpenConns(1)
+	}
+	t := &Tracker{db: db, dropAfter: dropAfter, retention: retention, client: &http.Client{Timeout: 10 * time.Second}, queue: make(chan *Submission, queueSize)}
+	go func() {
+		for s := range t.queue {
+			t.save(s)
+		}
+	}()
+	return t, nil
+}
+
+// Record 将已接受的交易加入写入队列, 不阻塞请求, 队列已满时丢弃
+func (t *Tracker) Record(tx *ethtypes.Transaction, sender common.Address, key string) {
+	s := newSubmission(tx, sender, key)
+	select {
+	case t.queue <- s:
+	default:
+		log.Printf("receipts: queue full, dropping %s", s.Hash)
+	}
+}
+
+func newSubmission(tx *ethtypes.Transaction, sender common.Address, key string) *Submission {
+	return &Submission{Hash: tx.Hash().Hex(), Sender: sender.Hex(), Nonce: tx.Nonce(), APIKey: key, Status: StatusPending}
+}
+
+// save 写入记录, 重复提交不覆盖已有记录
+func (t *Tracker) save(s *Submission) {
+	if err := t.db.Clauses(clause.OnConflict{DoNothing: true}).Create(s).Error; err != nil {
+		log.Printf("receipts: record %s: %v", s.Hash, err)
+	}
+}
+
+// Get 返回交易记录, 未知的交易返回 nil
+func (t *Tracker) Get(hash common.Hash) (*Submission, error) {
+	var s Submission
+	err := t.db.Where("hash = ?", hash.Hex()).Take(&s).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &s, nil
+}
+
+// Start 每个 interval 检查未完成的交易并发送 webhook, ctx 取消后退出
+func (t *Tracker) Start(ctx context.Context, interval time.Duration, chain func(context.Context) (Chain, error), webhook Webhook) {
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			}
+			c, err := chain(ctx)
+			if err != nil {
+				log.Printf("receipts: %v", err)
+				continue
+			}
+			t.check(ctx, c)
+			t.notify(ctx, webhook)
+			t.prune()
+		}
+	}()
+}
+
+func (t *Tracker) check(ctx context.Context, c Chain) {
+	var pending []Submission
+	if err := t.db.Where("status = ?", StatusPending).Order("created_at").Limit(1000).Find(&pending).Error; err != nil {
+		log.Printf("receipts: %v", err)
+		return
+	}
+	for _, s := range pending {
+		ctx, cancel := context.WithTimeout(ctx, callTimeout)
+		status, err := t.status(ctx, c, &s)
+		cancel()
+		if err != nil {
+			log.Printf("receipts: %s: %v", s.Hash, err)
+			continue
+		}
+		if status == StatusPending {
+			continue
+		}
+		s.Status = status
+		if err := t.db.Save(&s).Error; err != nil {
+			log.Printf("receipts: %s: %v", s.Hash, err)
+		}
+	}
+}
+
+// status 依次检查回执, 交易池和发送方的 nonce
+func (t *Tracker) status(ctx context.Context, c Chain, s *Submission) (string, error) {
+	hash := common.HexToHash(s.Hash)
+	included, err := t.included(ctx, c, hash, s)
+	if included || err != nil {
+		return StatusIncluded, err
+	}
+
+	nonce, err := c.NonceAt(ctx, common.HexToAddress(s.Sender), nil)
+	if err != nil {
+		return "", err
+	}
+	if nonce > s.Nonce {
+		// 查询 nonce 前交易可能刚好上链
+		if included, err := t.included(ctx, c, hash, s); included || err != nil {
+			return StatusIncluded, err
+		}
+		return StatusReplaced, nil
+	}
+
+	_, _, err = c.TransactionByHash(ctx, hash)
+	switch {
+	case err == nil:
+		return StatusPending, nil
+	case !errors.Is(err, ethereum.NotFound):
+		return "", err
+	case time.Since(s.CreatedAt) > t.dropAfter:
+		return StatusDropped, nil
+	}
+	return StatusPending, nil
+}
+
+// included 交易已上链时将回执写入 s
+func (t *Tracker) included(ctx context.Context, c Chain, hash common.Hash, s *Submission) (bool, error) {
+	receipt, err := c.TransactionReceipt(ctx, hash)
+	if errors.Is(err, ethereum.NotFound) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	s.BlockNumber, s.BlockHash = receipt.BlockNumber.Uint64(), receipt.BlockHash.Hex()
+	s.Success, s.GasUsed = receipt.Status == ethtypes.ReceiptStatusSuccessful, receipt.GasUsed
+	return true, nil
+}
+
+// notify 交易上链后向 API key 配置的地址发送 webhook
+func (t *Tracker) notify(ctx context.Context, webhook Webhook) {
+	var included []Submission
+	if err := t.db.Where("status = ? AND notified = ? AND notify_attempts < ? AND api_key <> ''", StatusIncluded, false, maxNotifyAttempts).
+		Limit(100).Find(&included).Error; err != nil {
+		log.Printf("receipts: %v", err)
+		return
+	}
+	for _, s := range included {
+		url, secret := webhook(s.APIKey)
+		if url == "" {
+			s.Notified = true
+		} else if err := t.post(ctx, url, secret, &s); err != nil {
+			s.NotifyAttempts++
+			log.Printf("receipts: webhook %s for %s: %v", s.APIKey, s.Hash, err)
+		} else {
+			s.Notified = true
+		}
+		if err := t.db.Save(&s).Error; err != nil {
+			log.Printf("receipts: %s: %v", s.Hash, err)
+		}
+	}
+}
+
+// post 签名为 hex(HMAC-SHA256(secret, timestamp + "." + body)), 接收方应检查时间戳防止重放
+func (t *Tracker) post(ctx context.Context, url, secret string, s *Submission) error {
+	body, err := json.Marshal(s)
+	if err != nil {
+		return err
+	}
+	ts := strconv.FormatInt(time.Now().Unix(), 10)
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("X-48-Timestamp", ts)
+	req.Header.Set("X-48-Signature", Sign(secret, ts, body))
+
+	resp, err := t.client.Do(req)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		return fmt.Errorf("status %d", resp.StatusCode)
+	}
+	return nil
+}
+
+// Sign webhook 的签名, 接收方用相同的密钥验证
+func Sign(secret, ts string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(ts + "."))
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// prune 删除超过保留时长未更新的记录
+func (t *Tracker) prune() {
+	if err := t.db.Where("updated_at < ?", time.Now().Add(-t.retention)).Delete(&Submission{}).Error; err != nil {
+		log.Printf("receipts: %v", err)
+	}
+}
@@ -0,0 +1,101 @@
+package receipts
+
+import (
+	"context"
+	"io"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/ethereum/go-ethereum"
+	"github.com/ethereum/go-ethereum/common"
+	ethtypes "github.com/ethereum/go-ethereum/core/types"
+)
+
+type fakeChain struct {
+	included map[common.Hash]uint64
+	pool     map[common.Hash]bool
+	nonce    uint64
+}
+
+func (c *fakeChain) TransactionReceipt(_ context.Context, hash common.Hash) (*ethtypes.Receipt, error) {
+	if n, ok := c.included[hash]; ok {
+		return &ethtypes.Receipt{BlockNumber: new(big.Int).SetUint64(n), Status: ethtypes.ReceiptStatusSuccessful, GasUsed: 21000}, nil
+	}
+	return nil, ethereum.NotFound
+}
+
+func (c *fakeChain) TransactionByHash(_ context.Context, hash common.Hash) (*ethtypes.Transaction, bool, error) {
+	if c.pool[hash] {
+		return nil, true, nil
+	}
+	return nil, false, ethereum.NotFound
+}
+
+func (c *fakeChain) NonceAt(context.Context, common.Address, *big.Int) (uint64, error) {
+	return c.nonce, nil
+}
+
+func TestTracker(t *testing.T) {
+	tr, err := Open(filepath.Join(t.TempDir(), "receipts.db"), time.Hour, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tx := func(nonce uint64) *ethtypes.Transaction {
+		return ethtypes.NewTx(&ethtypes.LegacyTx{Nonce: nonce, GasPrice: big.NewInt(1), Gas: 21000})
+	}
+	sender := common.HexToAddress("0x48")
+	inPool, included, replaced := tx(5), tx(6), tx(7)
+	replacement := ethtypes.NewTx(&ethtypes.LegacyTx{Nonce: 7, GasPrice: big.NewInt(2), Gas: 21000})
+	tr.save(newSubmission(inPool, sender, ""))
+	tr.save(newSubmission(included, sender, "partner"))
+	tr.save(newSubmission(replaced, sender, ""))
+	tr.save(newSubmission(included, sender, "other"))
+
+	chain := &fakeChain{
+		included: map[common.Hash]uint64{included.Hash(): 100, replacement.Hash(): 101},
+		pool:     map[common.Hash]bool{inPool.Hash(): true},
+		nonce:    5,
+	}
+	tr.check(context.Background(), chain)
+	for _, c := range []struct {
+		tx     *ethtypes.Transaction
+		status string
+	}{{inPool, StatusPending}, {included, StatusIncluded}, {replaced, StatusPending}} {
+		if s, _ := tr.Get(c.tx.Hash()); s == nil || s.Status != c.status {
+			t.Fatalf("nonce %d: got %+v, want %s", c.tx.Nonce(), s, c.status)
+		}
+	}
+	chain.nonce = 8
+	tr.check(context.Background(), chain)
+	if s, _ := tr.Get(replaced.Hash()); s.Status != StatusReplaced {
+		t.Fatalf("got %s, want %s", s.Status, StatusReplaced)
+	}
+	if s, _ := tr.Get(common.HexToHash("0x1")); s != nil {
+		t.Fatalf("unknown tx: got %+v", s)
+	}
+
+	var calls int
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls++
+		body, _ := io.ReadAll(r.Body)
+		if r.Header.Get("X-48-Signature") != Sign("s3cret", r.Header.Get("X-48-Timestamp"), body) {
+			w.WriteHeader(http.StatusUnauthorized)
+		}
+	}))
+	defer srv.Close()
+	webhook := func(key string) (string, string) {
+		if key == "partner" {
+			return srv.URL, "s3cret"
+		}
+		return "", ""
+	}
+	tr.notify(context.Background(), webhook)
+	tr.notify(context.Background(), webhook)
+	if s, _ := tr.Get(included.Hash()); calls != 1 || !s.Notified {
+		t.Fatalf("webhook calls %d, notified %v", calls, s.Notified)
+	}
+}
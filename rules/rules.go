@@ -0,0 +1,70 @@
+package rules
+
+import (
+	"fmt"
+	"net/http"
+	"regexp"
+	"strings"
+)
+
+const (
+	Allow   = "allow"   // 命中后不再检查后续规则
+	Deny    = "deny"    // 返回 403
+	Profile = "profile" // 使用指定的限速配置
+)
+
+// Rule 按请求头匹配, Missing 为 true 时匹配请求头缺失或为空, 否则按 Match 匹配请求头的值
+type Rule struct {
+	Name    string
+	Header  string
+	Missing bool
+	Action  string
+	Profile string
+
+	re *regexp.Regexp
+}
+
+// New match 为通配符模式, * 匹配任意字符, 不区分大小写
+func New(name, header, match string, missing bool, action, profile string) (*Rule, error) {
+	r := &Rule{Name: name, Header: http.CanonicalHeaderKey(header), Missing: missing, Action: action, Profile: profile}
+	if r.Header == "" {
+		return nil, fmt.Errorf("rule %s: header is required", name)
+	}
+	switch action {
+	case Allow, Deny:
+	case Profile:
+		if profile == "" {
+			return nil, fmt.Errorf("rule %s: profile is required", name)
+		}
+	default:
+		return nil, fmt.Errorf("rule %s: unknown action %s", name, action)
+	}
+	if missing == (match != "") {
+		return nil, fmt.Errorf("rule %s: exactly one of match and missing is required", name)
+	}
+	if match != "" {
+		r.re = regexp.MustCompile("(?i)^" + strings.ReplaceAll(regexp.QuoteMeta(match), `\*`, ".*") + "$")
+	}
+	return r, nil
+}
+
+func (r *Rule) match(h http.Header) bool {
+	v := h.Get(r.Header)
+	if r.Missing {
+		return v == ""
+	}
+	return v != "" && r.re.MatchString(v)
+}
+
+// Set 按顺序匹配, 第一条命中的规则生效
+type Set []*Rule
+
+// Match 没有规则命中时返回 nil
+func (s Set) Match(h http.Header) *Rule {
+	for _, r := range s {
+		if r.match(h) {
+			return r
+		}
+	}
+	return nil
+}
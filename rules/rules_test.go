@@ -0,0 +1,48 @@
+package rules
+
+import (
+	"net/http"
+	"testing"
+)
+
+func TestMatch(t *testing.T) {
+	rule := func(name, header, match string, missing bool, action, profile string) *Rule {
+		r, err := New(name, header, match, missing, action, profile)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return r
+	}
+	s := Set{
+		rule("wallet", "origin", "https://*.wallet.io", false, Allow, ""),
+		rule("headless", "User-Agent", "*HeadlessChrome*", false, Deny, ""),
+		rule("embed", "Referer", "https://*.scam.site/*", false, Deny, ""),
+		rule("no-ua", "User-Agent", "", true, Profile, "strict"),
+	}
+
+	for _, c := range []struct {
+		header http.Header
+		want   string
+	}{
+		{http.Header{"Origin": {"https://app.wallet.io"}, "User-Agent": {"HeadlessChrome/120"}}, "wallet"},
+		{http.Header{"User-Agent": {"Mozilla/5.0 headlesschrome/120"}}, "headless"},
+		{http.Header{"User-Agent": {"curl/8"}, "Referer": {"https://www.scam.site/page"}}, "embed"},
+		{http.Header{"User-Agent": {"curl/8"}, "Referer": {"https://scam.site.example/"}}, ""},
+		{http.Header{}, "no-ua"},
+	} {
+		got := ""
+		if r := s.Match(c.header); r != nil {
+			got = r.Name
+		}
+		if got != c.want {
+			t.Errorf("%v: got %q, want %q", c.header, got, c.want)
+		}
+	}
+
+	if _, err := New("bad", "Origin", "x", true, Deny, ""); err == nil {
+		t.Error("match and missing both set")
+	}
+	if _, err := New("bad", "Origin", "x", false, Profile, ""); err == nil {
+		t.Error("profile action without profile")
+	}
+}
@@ -0,0 +1,34 @@
+package server
+
+import (
+	"context"
+	"net/http"
+
+	"github.com/quic-go/quic-go"
+	"github.com/quic-go/quic-go/http3"
+)
+
+// NewHTTP3 在 TLS 端口的 UDP 上提供 HTTP/3, 证书与 TCP 的 HTTPS 共用
+// 不开启 0-RTT, 避免早期数据被重放绕过限速
+// 请求的信任策略与限速配置与同端口的 TCP 相同
+func NewHTTP3(l *Listener, store *CertStore, handler http.Handler) *http3.Server {
+	return &http3.Server{
+		Addr:       l.Address,
+		Handler:    handler,
+		TLSConfig:  store.TLSConfig(),
+		QUICConfig: &quic.Config{Allow0RTT: false},
+		ConnContext: func(ctx context.Context, _ *quic.Conn) context.Context {
+			return l.WithContext(ctx)
+		},
+	}
+}
+
+// AltSvc 在 HTTP/1.1 和 HTTP/2 的响应中通告 HTTP/3 端口
+func AltSvc(h3 *http3.Server, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.ProtoMajor < 3 {
+			h3.SetQUICHeaders(w.Header())
+		}
+		next.ServeHTTP(w, r)
+	})
+}
@@ -0,0 +1,95 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"net"
+	"net/netip"
+	"os"
+)
+
+// Listener 对外服务的一个监听地址, 每个地址可以有自己的信任策略与限速配置
+type Listener struct {
+	Network string      // tcp, unix 或 systemd
+	Address string      // tcp 为 host:port, unix 为文件路径, systemd 为 FileDescriptorName, 为空使用全部传入的 fd
+	Mode    fs.FileMode // unix socket 文件权限, 0 表示不修改
+	H2C     bool        // 同时接受明文 HTTP/2 (prior knowledge)
+	Local   bool        // 对端即客户端, 不经过 CDN 的信任检查
+	Profile string      // 该地址使用的限速配置, 为空按域名选择
+}
+
+func (l *Listener) String() string {
+	return l.Network + ":" + l.Address
+}
+
+// Listen 打开监听, systemd 可能对应多个 fd
+func (l *Listener) Listen() ([]net.Listener, error) {
+	switch l.Network {
+	case "", "tcp":
+		ln, err := net.Listen("tcp", l.Address)
+		if err != nil {
+			return nil, err
+		}
+		return []net.Listener{ln}, nil
+	case "unix":
+		ln, err := listenUnix(l.Address, l.Mode)
+		if err != nil {
+			return nil, err
+		}
+		return []net.Listener{ln}, nil
+	case "systemd":
+		return systemdListeners(l.Address)
+	}
+	return nil, fmt.Errorf("unknown listener network: %s", l.Network)
+}
+
+func listenUnix(path string, mode fs.FileMode) (net.Listener, error) {
+	// 上次异常退出遗留的 socket 文件
+	if fi, err := os.Stat(path); err == nil && fi.Mode()&fs.ModeSocket != 0 {
+		if err := os.Remove(path); err != nil {
+			return nil, err
+		}
+	} else if err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return nil, err
+	}
+
+	ln, err := net.Listen("unix", path)
+	if err != nil {
+		return nil, err
+	}
+	if mode != 0 {
+		if err := os.Chmod(path, mode); err != nil {
+			ln.Close()
+			return nil, err
+		}
+	}
+	return ln, nil
+}
+
+type listenerKey struct{}
+
+// BaseContext 用于 http.Server.BaseContext, 请求处理时通过 ListenerOf 取回
+func (l *Listener) BaseContext(net.Listener) context.Context {
+	return l.WithContext(context.Background())
+}
+
+// WithContext 将监听地址附加到 ctx, 用于 HTTP/3 等不使用 BaseContext 的服务
+func (l *Listener) WithContext(ctx context.Context) context.Context {
+	return context.WithValue(ctx, listenerKey{}, l)
+}
+
+// ListenerOf 返回请求所属的监听地址, 不是通过 Listener 启动的服务返回 nil
+func ListenerOf(ctx context.Context) *Listener {
+	l, _ := ctx.Value(listenerKey{}).(*Listener)
+	return l
+}
+
+// LocalAddr Local 监听地址上的客户端地址, unix socket 没有 IP, 统一记为 127.0.0.1
+func LocalAddr(remote string) netip.Addr {
+	if addr, err := netip.ParseAddr(remote); err == nil {
+		return addr.Unmap()
+	}
+	return netip.AddrFrom4([4]byte{127, 0, 0, 1})
+}
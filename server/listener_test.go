@@ -0,0 +1,59 @@
+package server
+
+import (
+	"context"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestUnixListener(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "agent.sock")
+	// 遗留的 socket 文件会被清理
+	stale, err := net.Listen("unix", path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	stale.(*net.UnixListener).SetUnlinkOnClose(false)
+	stale.Close()
+
+	l := &Listener{Network: "unix", Address: path, Mode: 0o660, Local: true, Profile: "local"}
+	lns, err := l.Listen()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fi, err := os.Stat(path); err != nil || fi.Mode().Perm() != 0o660 {
+		t.Fatalf("mode: %v %v", fi.Mode(), err)
+	}
+
+	srv := &http.Server{
+		BaseContext: l.BaseContext,
+		Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			io.WriteString(w, ListenerOf(r.Context()).Profile+" "+LocalAddr(r.RemoteAddr).String())
+		}),
+	}
+	go srv.Serve(lns[0])
+	defer srv.Close()
+
+	client := &http.Client{Transport: &http.Transport{
+		DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+			return net.Dial("unix", path)
+		},
+	}}
+	resp, err := client.Get("http://agent/")
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, _ := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if string(body) != "local 127.0.0.1" {
+		t.Fatalf("got %q", body)
+	}
+
+	if _, err := (&Listener{Network: "systemd"}).Listen(); err == nil {
+		t.Fatal("systemd listener without activation")
+	}
+}
@@ -0,0 +1,63 @@
+package server
+
+import (
+	"context"
+	"crypto/tls"
+	"net"
+	"net/netip"
+	"time"
+
+	"github.com/pires/go-proxyproto"
+)
+
+// ProxyListener 解析 PROXY protocol v1/v2 头, 只接受 trusted 返回 true 的来源发送的头
+// 其他来源的连接按普通连接处理, 携带 PROXY 头会导致 HTTP 解析失败
+// required 为 true 时受信任来源必须发送 PROXY 头
+func ProxyListener(ln net.Listener, trusted func(netip.Addr) bool, required bool, timeout time.Duration) net.Listener {
+	return &proxyproto.Listener{
+		Listener:          ln,
+		ReadHeaderTimeout: timeout,
+		ConnPolicy: func(opts proxyproto.ConnPolicyOptions) (proxyproto.Policy, error) {
+			addr, err := netip.ParseAddrPort(opts.Upstream.String())
+			if err != nil || !trusted(addr.Addr().Unmap()) {
+				return proxyproto.SKIP, nil
+			}
+			if required {
+				return proxyproto.REQUIRE, nil
+			}
+			return proxyproto.USE, nil
+		},
+	}
+}
+
+type connKey struct{}
+
+// ConnContext 用于 http.Server.ConnContext, 记录连接以便请求处理时读取 PROXY 头
+// 这里不能读取 PROXY 头, 否则会阻塞 accept 循环
+func ConnContext(ctx context.Context, c net.Conn) context.Context {
+	if tc, ok := c.(*tls.Conn); ok {
+		c = tc.NetConn()
+	}
+	if pc, ok := c.(*proxyproto.Conn); ok {
+		return context.WithValue(ctx, connKey{}, pc)
+	}
+	return ctx
+}
+
+// ProxiedAddr 返回 PROXY 头中的客户端地址, 连接未经过 PROXY protocol 时返回 false
+// LOCAL 命令 (如负载均衡的健康检查) 不携带客户端地址, 同样返回 false
+func ProxiedAddr(ctx context.Context) (netip.Addr, bool) {
+	pc, ok := ctx.Value(connKey{}).(*proxyproto.Conn)
+	if !ok {
+		return netip.Addr{}, false
+	}
+	h := pc.ProxyHeader()
+	if h == nil || !h.Command.IsProxy() {
+		return netip.Addr{}, false
+	}
+	addr, err := netip.ParseAddrPort(h.SourceAddr.String())
+	if err != nil {
+		return netip.Addr{}, false
+	}
+	return addr.Addr().Unmap(), true
+}
@@ -0,0 +1,66 @@
+package server
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/netip"
+	"strings"
+	"testing"
+	"time"
+)
+
+func serve(t *testing.T, trusted bool) string {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	srv := &http.Server{
+		ConnContext: ConnContext,
+		Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			addr, ok := ProxiedAddr(r.Context())
+			fmt.Fprintf(w, "%s %v", addr, ok)
+		}),
+	}
+	go srv.Serve(ProxyListener(ln, func(netip.Addr) bool { return trusted }, false, time.Second))
+	t.Cleanup(func() { srv.Close() })
+	return ln.Addr().String()
+}
+
+func get(t *testing.T, addr, header string) string {
+	conn, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	fmt.Fprint(conn, header+"GET / HTTP/1.1\r\nHost: x\r\nConnection: close\r\n\r\n")
+	resp, err := http.ReadResponse(bufio.NewReader(conn), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, _ := io.ReadAll(resp.Body)
+	return resp.Status + " " + string(body)
+}
+
+func TestProxyListener(t *testing.T) {
+	header := "PROXY TCP4 203.0.113.7 10.0.0.1 51234 80\r\n"
+
+	addr := serve(t, true)
+	if got := get(t, addr, header); !strings.HasSuffix(got, "203.0.113.7 true") {
+		t.Fatalf("trusted proxied: %s", got)
+	}
+	if got := get(t, addr, ""); !strings.HasSuffix(got, "invalid IP false") {
+		t.Fatalf("trusted without header: %s", got)
+	}
+
+	// 不受信任的来源发送 PROXY 头会被当作 HTTP 请求解析
+	addr = serve(t, false)
+	if got := get(t, addr, header); !strings.HasPrefix(got, "400") {
+		t.Fatalf("untrusted proxied: %s", got)
+	}
+	if got := get(t, addr, ""); !strings.HasSuffix(got, "invalid IP false") {
+		t.Fatalf("untrusted direct: %s", got)
+	}
+}
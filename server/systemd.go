@@ -0,0 +1,80 @@
+package server
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+)
+
+// systemd socket activation 传入的 fd 从 3 开始
+const listenFdsStart = 3
+
+type activated struct {
+	name string
+	ln   net.Listener
+}
+
+var (
+	activatedOnce sync.Once
+	activatedFds  []activated
+	activatedErr  error
+)
+
+// systemdListeners 按 FileDescriptorName 返回 systemd 传入的监听, name 为空返回全部
+func systemdListeners(name string) ([]net.Listener, error) {
+	activatedOnce.Do(func() { activatedFds, activatedErr = loadActivated() })
+	if activatedErr != nil {
+		return nil, activatedErr
+	}
+
+	lns := []net.Listener{}
+	for _, a := range activatedFds {
+		if name == "" || a.name == name {
+			lns = append(lns, a.ln)
+		}
+	}
+	if len(lns) == 0 {
+		return nil, fmt.Errorf("no systemd socket named %q", name)
+	}
+	return lns, nil
+}
+
+// loadActivated 读取 LISTEN_PID, LISTEN_FDS, LISTEN_FDNAMES, 读取后清除, 避免子进程误用
+func loadActivated() ([]activated, error) {
+	defer func() {
+		os.Unsetenv("LISTEN_PID")
+		os.Unsetenv("LISTEN_FDS")
+		os.Unsetenv("LISTEN_FDNAMES")
+	}()
+
+	if pid, err := strconv.Atoi(os.Getenv("LISTEN_PID")); err != nil || pid != os.Getpid() {
+		return nil, fmt.Errorf("not started by systemd socket activation")
+	}
+	n, err := strconv.Atoi(os.Getenv("LISTEN_FDS"))
+	if err != nil || n <= 0 {
+		return nil, fmt.Errorf("bad LISTEN_FDS: %q", os.Getenv("LISTEN_FDS"))
+	}
+	names := strings.Split(os.Getenv("LISTEN_FDNAMES"), ":")
+
+	fds := []activated{}
+	for i := range n {
+		fd := listenFdsStart + i
+		syscall.CloseOnExec(fd)
+		name := "unknown" // 与 systemd 未设置 FileDescriptorName 时一致
+		if i < len(names) && names[i] != "" {
+			name = names[i]
+		}
+		f := os.NewFile(uintptr(fd), name)
+		ln, err := net.FileListener(f)
+		f.Close()
+		if err != nil {
+			return nil, fmt.Errorf("systemd fd %d (%s): %w", fd, name, err)
+		}
+		fds = append(fds, activated{name: name, ln: ln})
+	}
+	return fds, nil
+}
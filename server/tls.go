@@ -0,0 +1,186 @@
+package server
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+// Site 一个域名的证书配置, 配置了 ClientCAs 时要求客户端证书 (mTLS)
+type Site struct {
+	Cert      string
+	Key       string
+	ClientCAs []string
+}
+
+func (s Site) files() []string {
+	return append([]string{s.Cert, s.Key}, s.ClientCAs...)
+}
+
+type site struct {
+	Site
+	config  atomic.Pointer[tls.Config]
+	modTime time.Time // 所有文件中最新的修改时间, 只在 Watch 中读写
+}
+
+// CertStore 按 SNI 选择证书, 文件变化后自动重新加载
+type CertStore struct {
+	base  *tls.Config
+	sites map[string]*site // 域名 => 证书, 支持 *.example.com, default 用于未匹配的 SNI
+}
+
+// NewCertStore base 为公共配置 (最低版本, 加密套件等), 启动时任一证书加载失败即返回错误
+func NewCertStore(base *tls.Config, sites map[string]Site) (*CertStore, error) {
+	if len(base.NextProtos) == 0 {
+		base.NextProtos = []string{"h2", "http/1.1"}
+	}
+	s := &CertStore{base: base, sites: map[string]*site{}}
+	for name, cfg := range sites {
+		st := &site{Site: cfg}
+		if err := s.load(st); err != nil {
+			return nil, fmt.Errorf("tls %s: %w", name, err)
+		}
+		s.sites[strings.ToLower(name)] = st
+	}
+	return s, nil
+}
+
+func (s *CertStore) load(st *site) error {
+	modTime, err := latestModTime(st.files())
+	if err != nil {
+		return err
+	}
+
+	cert, err := tls.LoadX509KeyPair(st.Cert, st.Key)
+	if err != nil {
+		return err
+	}
+	cfg := s.base.Clone()
+	cfg.Certificates = []tls.Certificate{cert}
+
+	if len(st.ClientCAs) > 0 {
+		pool := x509.NewCertPool()
+		for _, file := range st.ClientCAs {
+			pem, err := os.ReadFile(file)
+			if err != nil {
+				return err
+			}
+			if !pool.AppendCertsFromPEM(pem) {
+				return fmt.Errorf("no certificate found in %s", file)
+			}
+		}
+		cfg.ClientCAs = pool
+		cfg.ClientAuth = tls.RequireAndVerifyClientCert
+	}
+
+	st.config.Store(cfg)
+	st.modTime = modTime
+	return nil
+}
+
+func latestModTime(files []string) (t time.Time, err error) {
+	for _, file := range files {
+		fi, err := os.Stat(file)
+		if err != nil {
+			return t, err
+		}
+		if fi.ModTime().After(t) {
+			t = fi.ModTime()
+		}
+	}
+	return
+}
+
+// TLSConfig 用于 http.Server.TLSConfig
+func (s *CertStore) TLSConfig() *tls.Config {
+	cfg := s.base.Clone()
+	cfg.GetConfigForClient = s.configFor
+	return cfg
+}
+
+func (s *CertStore) configFor(hello *tls.ClientHelloInfo) (*tls.Config, error) {
+	if st := s.lookup(hello.ServerName); st != nil {
+		return st.config.Load(), nil
+	}
+	return nil, fmt.Errorf("no certificate for %q", hello.ServerName)
+}
+
+// lookup 精确匹配, 其次通配符, 最后 default
+func (s *CertStore) lookup(name string) *site {
+	name = strings.ToLower(name)
+	if st, ok := s.sites[name]; ok {
+		return st
+	}
+	if i := strings.IndexByte(name, '.'); i > 0 {
+		if st, ok := s.sites["*"+name[i:]]; ok {
+			return st
+		}
+	}
+	return s.sites["default"]
+}
+
+// Watch 定期检查证书文件的修改时间, 变化后重新加载, 加载失败时继续使用旧证书
+func (s *CertStore) Watch(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		for name, st := range s.sites {
+			modTime, err := latestModTime(st.files())
+			if err != nil || !modTime.After(st.modTime) {
+				continue
+			}
+			if err := s.load(st); err != nil {
+				log.Printf("tls %s reload failed: %v", name, err)
+				continue
+			}
+			log.Printf("tls %s reloaded", name)
+		}
+	}
+}
+
+// ParseVersion 解析 1.0 / 1.1 / 1.2 / 1.3, 为空返回 TLS 1.2
+func ParseVersion(s string) (uint16, error) {
+	switch s {
+	case "1.0":
+		return tls.VersionTLS10, nil
+	case "1.1":
+		return tls.VersionTLS11, nil
+	case "", "1.2":
+		return tls.VersionTLS12, nil
+	case "1.3":
+		return tls.VersionTLS13, nil
+	}
+	return 0, fmt.Errorf("unknown tls version: %s", s)
+}
+
+// ParseCipherSuites 按名称解析加密套件, 只接受 Go 认为安全的套件, 为空使用 Go 的默认值
+// TLS 1.3 的套件不可配置
+func ParseCipherSuites(names []string) ([]uint16, error) {
+	if len(names) == 0 {
+		return nil, nil
+	}
+	ids := map[string]uint16{}
+	for _, c := range tls.CipherSuites() {
+		ids[c.Name] = c.ID
+	}
+	suites := []uint16{}
+	for _, name := range names {
+		id, ok := ids[name]
+		if !ok {
+			return nil, fmt.Errorf("unknown or insecure cipher suite: %s", name)
+		}
+		suites = append(suites, id)
+	}
+	return suites, nil
+}
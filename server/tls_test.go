@@ -0,0 +1,98 @@
+package server
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func writeCert(t *testing.T, dir, name string, modTime time.Time) Site {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(time.Now().UnixNano()),
+		Subject:      pkix.Name{CommonName: name},
+		DNSNames:     []string{name},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	s := Site{Cert: filepath.Join(dir, name+".crt"), Key: filepath.Join(dir, name+".key")}
+	os.WriteFile(s.Cert, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0o600)
+	os.WriteFile(s.Key, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}), 0o600)
+	os.Chtimes(s.Cert, modTime, modTime)
+	os.Chtimes(s.Key, modTime, modTime)
+	return s
+}
+
+func serverName(t *testing.T, s *CertStore, sni string) string {
+	cfg, err := s.configFor(&tls.ClientHelloInfo{ServerName: sni})
+	if err != nil {
+		return ""
+	}
+	cert, err := x509.ParseCertificate(cfg.Certificates[0].Certificate[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	return cert.Subject.CommonName
+}
+
+func TestCertStore(t *testing.T) {
+	dir := t.TempDir()
+	old := time.Now().Add(-time.Minute)
+	s, err := NewCertStore(&tls.Config{}, map[string]Site{
+		"rpc.example.com": writeCert(t, dir, "rpc.example.com", old),
+		"*.example.org":   writeCert(t, dir, "wild.example.org", old),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for sni, want := range map[string]string{
+		"RPC.example.com": "rpc.example.com",
+		"a.example.org":   "wild.example.org",
+		"a.b.example.org": "",
+		"other.com":       "",
+	} {
+		if got := serverName(t, s, sni); got != want {
+			t.Errorf("%s: got %q, want %q", sni, got, want)
+		}
+	}
+
+	// 覆盖证书文件后自动重新加载
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go s.Watch(ctx, 10*time.Millisecond)
+	site := s.sites["rpc.example.com"].Site
+	next := writeCert(t, dir, "new.example.com", time.Now())
+	os.Rename(next.Cert, site.Cert)
+	os.Rename(next.Key, site.Key)
+
+	deadline := time.Now().Add(time.Second)
+	for serverName(t, s, "rpc.example.com") != "new.example.com" {
+		if time.Now().After(deadline) {
+			t.Fatal("certificate not reloaded")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
@@ -0,0 +1,54 @@
+package tools
+
+import (
+	"fmt"
+
+	"github.com/48Club/service_agent/bundle"
+	"github.com/48Club/service_agent/config"
+	"github.com/48Club/service_agent/types"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/gin-gonic/gin"
+)
+
+// Bundle 校验通过的 bundle 请求, 原始参数转发给 builder
+type Bundle struct {
+	Method string
+	Params []any
+	Hash   common.Hash // eth_cancelBundle 为零值
+	Txs    []*RawTx
+}
+
+// decodeBundle 检查 bundle 结构并逐笔校验交易, 校验规则与 eth_sendRawTransaction 相同
+func decodeBundle(host string, req types.Web3ClientRequest) (gin.H, *Bundle) {
+	args, err := bundle.ParseArgs(req.Method, req.Params, config.Get().Bundle.MaxTxs)
+	if err != nil {
+		return buildGethError(req, &types.Web3Error{Code: -32602, Message: err.Error()}), nil
+	}
+	b := &Bundle{Method: req.Method, Params: req.Params}
+	if req.Method == bundle.MethodCancel {
+		return nil, b
+	}
+
+	hashes := make([]common.Hash, len(args.Txs))
+	included := map[common.Hash]bool{}
+	for i, raw := range args.Txs {
+		tx, txErr := decodeRawTx(host, []any{hexutil.Encode(raw)})
+		if txErr != nil {
+			return buildGethError(req, &types.Web3Error{Code: txErr.Code, Message: fmt.Sprintf("tx %d: %s", i, txErr.Message)}), nil
+		}
+		hashes[i] = tx.Tx.Hash()
+		if included[hashes[i]] {
+			return buildGethError(req, &types.Web3Error{Code: -32602, Message: fmt.Sprintf("tx %d: duplicate transaction %s", i, hashes[i])}), nil
+		}
+		included[hashes[i]] = true
+		b.Txs = append(b.Txs, tx)
+	}
+	for _, h := range args.RevertingTxHashes {
+		if !included[h] {
+			return buildGethError(req, &types.Web3Error{Code: -32602, Message: fmt.Sprintf("reverting tx %s not in bundle", h)}), nil
+		}
+	}
+	b.Hash = bundle.Hash(hashes)
+	return nil, b
+}
@@ -0,0 +1,84 @@
+package tools
+
+import (
+	"fmt"
+	"math/big"
+
+	"github.com/48Club/service_agent/config"
+	"github.com/48Club/service_agent/types"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	ethtypes "github.com/ethereum/go-ethereum/core/types"
+)
+
+// RawTx 解析并校验通过的 eth_sendRawTransaction
+type RawTx struct {
+	Raw    []byte
+	Tx     *ethtypes.Transaction
+	Sender common.Address
+}
+
+// 错误信息尽量与 geth 保持一致, 客户端可以沿用已有的错误处理
+func txError(format string, a ...any) *types.Web3Error {
+	return &types.Web3Error{Code: -32000, Message: fmt.Sprintf(format, a...)}
+}
+
+// decodeRawTx 解析交易并恢复发送方, 依次检查交易类型, chain id, data 大小, 签名和最低 gas price
+func decodeRawTx(host string, params []any) (*RawTx, *types.Web3Error) {
+	if len(params) < 1 {
+		return nil, &types.Web3Error{Code: -32602, Message: "missing value for required argument 0"}
+	}
+	s, ok := params[0].(string)
+	if !ok {
+		return nil, &types.Web3Error{Code: -32602, Message: "invalid argument 0: hex string expected"}
+	}
+	raw, err := hexutil.Decode(s)
+	if err != nil {
+		return nil, &types.Web3Error{Code: -32602, Message: "invalid argument 0: " + err.Error()}
+	}
+
+	tx := new(ethtypes.Transaction)
+	if err := tx.UnmarshalBinary(raw); err != nil {
+		return nil, txError("%s", err)
+	}
+
+	rc := config.Get().RawTx
+	if !rc.TxTypes.ContainsOne(tx.Type()) {
+		return nil, txError("%s: %d", ethtypes.ErrTxTypeNotSupported, tx.Type())
+	}
+
+	if !tx.Protected() {
+		if !rc.AllowUnprotected {
+			return nil, txError("only replay-protected (EIP-155) transactions allowed over RPC")
+		}
+	} else if want := chainID(); want != 0 && tx.ChainId().Cmp(new(big.Int).SetUint64(want)) != 0 {
+		return nil, txError("%s: have %d want %d", ethtypes.ErrInvalidChainId, tx.ChainId(), want)
+	}
+
+	if size := len(tx.Data()); size > rc.MaxDataSize {
+		return nil, txError("oversized data: transaction data size %d, limit %d", size, rc.MaxDataSize)
+	}
+
+	sender, err := ethtypes.Sender(ethtypes.LatestSignerForChainID(tx.ChainId()), tx)
+	if err != nil {
+		return nil, txError("invalid sender: %s", err)
+	}
+
+	// BSC 的 base fee 为 0, 实际支付的价格为 min(max fee, priority fee)
+	price := tx.GasTipCap()
+	if tx.GasFeeCap().Cmp(price) < 0 {
+		price = tx.GasFeeCap()
+	}
+	if floor := rc.MinGasPriceFor(host); floor > 0 && price.Cmp(new(big.Int).SetUint64(floor)) < 0 {
+		return nil, txError("transaction underpriced: gas price %d, minimum needed %d", price, floor)
+	}
+
+	return &RawTx{Raw: raw, Tx: tx, Sender: sender}, nil
+}
+
+func chainID() uint64 {
+	if id := config.Get().RawTx.ChainID; id != 0 {
+		return id
+	}
+	return config.Get().UpstreamPool.ChainID()
+}
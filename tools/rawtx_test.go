@@ -0,0 +1,63 @@
+package tools
+
+import (
+	"crypto/ecdsa"
+	"math/big"
+	"strings"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	ethtypes "github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/crypto"
+)
+
+// 测试使用 tools/config.json: chain id 56, data 上限 64 字节, 允许类型 0 和 2, 最低 1 gwei
+
+func signTx(t *testing.T, key *ecdsa.PrivateKey, signer ethtypes.Signer, data ethtypes.TxData) string {
+	tx, err := ethtypes.SignNewTx(key, signer, data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	raw, _ := tx.MarshalBinary()
+	return hexutil.Encode(raw)
+}
+
+func TestDecodeRawTx(t *testing.T) {
+	key, _ := crypto.GenerateKey()
+	to := common.HexToAddress("0x48")
+	gwei := big.NewInt(1e9)
+	bsc, chapel := ethtypes.LatestSignerForChainID(big.NewInt(56)), ethtypes.LatestSignerForChainID(big.NewInt(97))
+	dynamic := func(tip, feeCap *big.Int, data []byte) *ethtypes.DynamicFeeTx {
+		return &ethtypes.DynamicFeeTx{ChainID: big.NewInt(56), GasTipCap: tip, GasFeeCap: feeCap, Gas: 21000, To: &to, Data: data}
+	}
+
+	for _, c := range []struct {
+		name  string
+		raw   string
+		error string
+	}{
+		{"malformed rlp", "0x02c0ff", "rlp"},
+		{"not hex", "0x4", "invalid argument 0"},
+		{"wrong chain id", signTx(t, key, chapel, &ethtypes.DynamicFeeTx{ChainID: big.NewInt(97), GasTipCap: gwei, GasFeeCap: gwei, Gas: 21000, To: &to}), "invalid chain id for signer: have 97 want 56"},
+		{"unprotected", signTx(t, key, ethtypes.HomesteadSigner{}, &ethtypes.LegacyTx{GasPrice: gwei, Gas: 21000, To: &to}), "replay-protected"},
+		{"tx type", signTx(t, key, bsc, &ethtypes.AccessListTx{ChainID: big.NewInt(56), GasPrice: gwei, Gas: 21000, To: &to}), "transaction type not supported: 1"},
+		{"data size", signTx(t, key, bsc, dynamic(gwei, gwei, make([]byte, 65))), "oversized data"},
+		{"low tip", signTx(t, key, bsc, dynamic(big.NewInt(1e9-1), big.NewInt(2e9), nil)), "underpriced: gas price 999999999"},
+		{"low fee cap", signTx(t, key, bsc, dynamic(big.NewInt(2e9), big.NewInt(1e9-1), nil)), "underpriced: gas price 999999999"},
+	} {
+		tx, err := decodeRawTx("rpc.48.club", []any{c.raw})
+		if tx != nil || err == nil || !strings.Contains(err.Message, c.error) {
+			t.Errorf("%s: got %v, %v", c.name, tx, err)
+		}
+	}
+
+	raw := signTx(t, key, bsc, dynamic(gwei, big.NewInt(2e9), make([]byte, 64)))
+	tx, err := decodeRawTx("rpc.48.club", []any{raw})
+	if err != nil {
+		t.Fatal(err.Message)
+	}
+	if tx.Sender != crypto.PubkeyToAddress(key.PublicKey) || hexutil.Encode(tx.Raw) != raw {
+		t.Errorf("got sender %s", tx.Sender)
+	}
+}
@@ -1,39 +1,32 @@
 package tools
 
 import (
-	"context"
 	"encoding/json"
 	"errors"
 	"math/big"
 	"net/http"
 	"strings"
-	"time"
 
+	"github.com/48Club/service_agent/blocktag"
+	"github.com/48Club/service_agent/broadcast"
+	"github.com/48Club/service_agent/bundle"
 	"github.com/48Club/service_agent/config"
+	"github.com/48Club/service_agent/logs"
+	"github.com/48Club/service_agent/receipts"
+	"github.com/48Club/service_agent/txcache"
 	"github.com/48Club/service_agent/types"
+	"github.com/48Club/service_agent/upstream"
 	mapset "github.com/deckarep/golang-set/v2"
 	"github.com/ethereum/go-ethereum/common"
 	"github.com/ethereum/go-ethereum/common/hexutil"
-	"github.com/ethereum/go-ethereum/ethclient"
 	"github.com/gin-gonic/gin"
 )
 
+// GetRpcStatus 返回后台轮询缓存的上游状态, 不再每次探测都请求上游
 func GetRpcStatus() int {
-	ec, err := ethclient.Dial(config.GlobalConfig.Sentry)
-	if err != nil {
-		return http.StatusInternalServerError
-	}
-	defer ec.Close()
-
-	block, err := ec.HeaderByNumber(context.Background(), nil)
-	if err != nil {
-		return http.StatusInternalServerError
-	}
-	// 区块不是 20 秒内的, 认为 RPC 不可用
-	if time.Since(time.Unix(int64(block.Time), 0)) > 20*time.Second {
+	if !config.Get().UpstreamPool.Ready() {
 		return http.StatusInternalServerError
 	}
-
 	return http.StatusNoContent
 }
 
@@ -53,70 +46,290 @@ func CheckJOSNType(body []byte) byte {
 
 var BadBatchRequest = errors.New("bad batch request")
 
-// buildRespByAgent: 是否需要由 agent 构建响应
-// resp: 由 agent 构建的响应
-// batchCount: 批量请求中非 eth_sendRawTransaction 的请求数量
-// sikpLimit: 是否跳过限制器
-func DecodeRequestBody(host string, body []byte) (resp gin.H, buildRespByAgent bool, batchCount int, skipLimit bool) {
-	batchCount = 1
+// Decoded 请求体的解析结果, 批量请求中的每一项可以单独由 agent 响应
+type Decoded struct {
+	Batch      bool
+	Requests   types.Web3ClientRequests // 单个请求时只有一个元素, 无法解析时为空
+	Responses  []gin.H                  // 与 Requests 一一对应, 非 nil 表示由 agent 生成响应
+	Txs        []*RawTx                 // 与 Requests 一一对应, 校验通过的 eth_sendRawTransaction
+	Bundles    []*Bundle                // 与 Requests 一一对应, 校验通过的 bundle 请求, 未配置 builder 时为 nil
+	LogQueries []*logs.Query            // 与 Requests 一一对应, 需要由 agent 拆分执行的 eth_getLogs
+	BatchCount int                      // 需要计入限速的请求数量, 不含 skip_limit_methods
+	SkipLimit  bool                     // 全部请求都在 skip_limit_methods 中
+	Methods    []string                 // 请求的方法名, 批量请求按顺序列出所有方法
+	Caller     string                   // 调用方的 API key, 没有时为 IP
+	Pinned     uint64                   // 区块标签改写使用的 latest, 转发时需要选择已达到该高度的上游, 未改写时为 0
+
+	body      []byte
+	raw       []json.RawMessage
+	rewritten bool // raw 中有请求被改写, 转发时需要重新编码
+}
+
+// Local 所有请求都由 agent 响应
+func (d *Decoded) Local() bool {
+	if len(d.Requests) == 0 {
+		return false
+	}
+	for _, r := range d.Responses {
+		if r == nil {
+			return false
+		}
+	}
+	return true
+}
+
+// Partial 批量请求中部分由 agent 响应, 其余需要转发给上游
+func (d *Decoded) Partial() bool {
+	if !d.Batch || d.Local() {
+		return false
+	}
+	for _, r := range d.Responses {
+		if r != nil {
+			return true
+		}
+	}
+	return false
+}
+
+// Response 全部由 agent 响应时的响应体, 单个请求为对象, 批量请求为数组
+func (d *Decoded) Response() any {
+	if d.Batch {
+		return d.Responses
+	}
+	return d.Responses[0]
+}
+
+// Forward 转发给上游的请求体, 批量请求去掉由 agent 响应的部分, 除改写的请求外原始内容不重新编码
+func (d *Decoded) Forward() []byte {
+	if !d.Partial() && !d.rewritten {
+		return d.body
+	}
+	if !d.Batch {
+		return d.raw[0]
+	}
+	forward := []json.RawMessage{}
+	for i, r := range d.Responses {
+		if r == nil {
+			forward = append(forward, d.raw[i])
+		}
+	}
+	body, _ := json.Marshal(forward)
+	return body
+}
+
+// Merge 合并上游对 Forward 的批量响应与 agent 生成的响应, JSON-RPC 批量响应不要求顺序
+func (d *Decoded) Merge(upstream []json.RawMessage) []any {
+	merged := make([]any, 0, len(d.Requests))
+	for _, r := range upstream {
+		merged = append(merged, r)
+	}
+	for _, r := range d.Responses {
+		if r != nil {
+			merged = append(merged, r)
+		}
+	}
+	return merged
+}
+
+// Respond 由 agent 响应第 i 个请求
+func (d *Decoded) Respond(i int, result any) {
+	d.Responses[i] = buildGethResponse(d.Requests[i], result)
+}
+
+// RespondError 由 agent 返回第 i 个请求的错误
+func (d *Decoded) RespondError(i int, err *types.Web3Error) {
+	d.Responses[i] = buildGethError(d.Requests[i], err)
+}
+
+// DecodeRequestBody 解析请求体, 由 agent 处理的请求生成响应, 无法解析的请求体原样转发
+// caller 为调用方的 API key, 没有时为 IP
+func DecodeRequestBody(host, caller string, body []byte) *Decoded {
+	d := &Decoded{BatchCount: 1, Caller: caller, body: body}
 	switch CheckJOSNType(body) {
 	case 123: // {
 		var web3Req types.Web3ClientRequest
 		err := json.Unmarshal(body, &web3Req)
 		if err != nil {
-			return
+			return d
 		}
-
-		if config.GlobalConfig.SkipLimitMethods.ContainsOne(web3Req.Method) {
-			skipLimit = true
-			return
+		d.raw = []json.RawMessage{body}
+		d.Requests = web3Req.Conv2Batch()
+	case 91: // [
+		d.Batch = true
+		if err := json.Unmarshal(body, &d.raw); err != nil {
+			return d
 		}
+		d.Requests = make(types.Web3ClientRequests, len(d.raw))
+		for i, raw := range d.raw {
+			if err := json.Unmarshal(raw, &d.Requests[i]); err != nil {
+				// 交给上游返回错误
+				d.Requests[i] = types.Web3ClientRequest{}
+			}
+		}
+	default:
+		return d
+	}
 
-		var _tmp string
-		switch web3Req.Method {
-		case "eth_gasPrice":
-			_tmp, buildRespByAgent = set1weiGasPrice(host)
-		case "eth_call":
-			_tmp, buildRespByAgent = decodeEthCall(web3Req.Params)
+	d.Responses = make([]gin.H, len(d.Requests))
+	d.Txs = make([]*RawTx, len(d.Requests))
+	d.Bundles = make([]*Bundle, len(d.Requests))
+	d.LogQueries = make([]*logs.Query, len(d.Requests))
+	var heads upstream.Heads
+	if config.Get().PinBlockTagsFor(host) {
+		heads = config.Get().UpstreamPool.AgreedHeads()
+	}
+	txCount := 0
+	for i := range d.Requests {
+		if heads.Latest > 0 {
+			d.pinBlockTags(i, heads)
 		}
-		if buildRespByAgent {
-			resp = buildGethResponse(web3Req, _tmp)
+		req := d.Requests[i]
+		d.Methods = append(d.Methods, req.Method)
+		if len(config.Get().Bundle.Builders) > 0 && bundle.IsBundle(req.Method) {
+			// bundle 单独限速
+			txCount++
+			d.Responses[i], d.Bundles[i] = decodeBundle(host, req)
+			continue
 		}
-	case 91: // [
-		var web3Reqs types.Web3ClientRequests
-		err := json.Unmarshal(body, &web3Reqs)
-		if err != nil {
-			return
+		if config.Get().SkipLimitMethods.ContainsOne(req.Method) {
+			txCount++
 		}
+		if req.Method == "eth_getLogs" {
+			d.Responses[i], d.LogQueries[i] = decodeGetLogs(host, req)
+			continue
+		}
+		d.Responses[i], d.Txs[i] = decodeRequest(host, caller, req)
+	}
+
+	if txCount == len(d.Requests) {
+		d.SkipLimit = true
+	}
+	if d.Batch {
+		d.BatchCount = len(d.Requests) - txCount
+	}
+	return d
+}
 
-		reqCount := len(web3Reqs)
-		txCount := 0
+// pinBlockTags 将第 i 个请求的区块标签改写为所有健康上游都已达到的区块号, 避免轮询到不同高度的节点时状态回退
+func (d *Decoded) pinBlockTags(i int, heads upstream.Heads) {
+	var fields map[string]json.RawMessage
+	if json.Unmarshal(d.raw[i], &fields) != nil {
+		return
+	}
+	params, ok := blocktag.Pin(d.Requests[i].Method, d.Requests[i].Params, heads)
+	if !ok {
+		return
+	}
+	fields["params"], _ = json.Marshal(params)
+	raw, err := json.Marshal(fields)
+	if err != nil {
+		return
+	}
+	d.Requests[i].Params, d.raw[i], d.rewritten = params, raw, true
+	d.Pinned = heads.Latest
+}
 
-		for _, v := range web3Reqs {
-			if config.GlobalConfig.SkipLimitMethods.ContainsOne(v.Method) {
-				txCount++
+// decodeRequest 返回由 agent 生成的响应, 为 nil 时转发给上游
+func decodeRequest(host, caller string, req types.Web3ClientRequest) (gin.H, *RawTx) {
+	var (
+		_tmp             string
+		buildRespByAgent bool
+	)
+	switch req.Method {
+	case "eth_sendRawTransaction":
+		tx, err := decodeRawTx(host, req.Params)
+		if err != nil {
+			return buildGethError(req, err), nil
+		}
+		// 钱包重试的交易直接返回上一次的结果, 不再发送
+		if e, ok := txcache.Txs.Get(tx.Tx.Hash()); ok {
+			if e.Err != nil {
+				return buildGethError(req, e.Err), nil
 			}
+			return buildGethResponse(req, e.Hash), nil
 		}
-
-		if txCount == reqCount {
-			skipLimit = true
-			return
+		if !txcache.Txs.Replace(tx.Sender, tx.Tx.Nonce(), tx.Tx.Hash()) {
+			return buildGethError(req, txError("replacement limit reached for nonce %d", tx.Tx.Nonce())), nil
 		}
+		return nil, tx
+	case "eth_getPrivateTransactionStatus":
+		status, err := privateTxStatus(caller, req.Params)
+		if err != nil {
+			return buildGethError(req, err), nil
+		}
+		return buildGethResponse(req, status), nil
+	case "eth_getTransactionSubmission":
+		submission, err := txSubmission(req.Params)
+		if err != nil {
+			return buildGethError(req, err), nil
+		}
+		return buildGethResponse(req, submission), nil
+	case "eth_call":
+		_tmp, buildRespByAgent = decodeEthCall(req.Params)
+	}
+	if buildRespByAgent {
+		return buildGethResponse(req, _tmp), nil
+	}
+	return nil, nil
+}
 
-		batchCount = reqCount - txCount
+// decodeGetLogs 检查 eth_getLogs 的限制, 超过限制时返回错误, 需要拆分时返回 Query
+func decodeGetLogs(host string, req types.Web3ClientRequest) (gin.H, *logs.Query) {
+	l := config.Get().LogsFor(host)
+	if l == nil {
+		return nil, nil
+	}
+	q, err := l.Check(req.Params, config.Get().UpstreamPool.Head())
+	if err != nil {
+		return buildGethError(req, err), nil
 	}
+	return nil, q
+}
 
-	return
+// privateTxStatus 查询隐私模式交易的发送记录, 未知, 已过期或不是 caller 提交的交易返回 null
+func privateTxStatus(caller string, params []any) (*broadcast.Status, *types.Web3Error) {
+	hash, err := hashParam(params)
+	if err != nil {
+		return nil, err
+	}
+	status, ok := broadcast.PrivateTxs.Get(hash, caller)
+	if !ok {
+		return nil, nil
+	}
+	return &status, nil
 }
 
-func set1weiGasPrice(h string) (string, bool) {
-	if h == "0.48.club" {
-		return "0x1", true
+// txSubmission 查询经 agent 提交的交易的上链情况, 未开启 receipts 时返回 method not found
+func txSubmission(params []any) (*receipts.Submission, *types.Web3Error) {
+	if receipts.Txs == nil {
+		return nil, &types.Web3Error{Code: -32601, Message: "the method eth_getTransactionSubmission does not exist/is not available"}
+	}
+	hash, err := hashParam(params)
+	if err != nil {
+		return nil, err
+	}
+	submission, dbErr := receipts.Txs.Get(hash)
+	if dbErr != nil {
+		return nil, &types.Web3Error{Code: -32000, Message: dbErr.Error()}
 	}
-	return "", false
+	return submission, nil
 }
 
-func buildGethResponse(i types.Web3ClientRequest, result string) gin.H {
+func hashParam(params []any) (hash common.Hash, err *types.Web3Error) {
+	if len(params) < 1 {
+		return hash, &types.Web3Error{Code: -32602, Message: "missing value for required argument 0"}
+	}
+	s, ok := params[0].(string)
+	if !ok {
+		return hash, &types.Web3Error{Code: -32602, Message: "invalid argument 0: hex string expected"}
+	}
+	if err := hash.UnmarshalText([]byte(s)); err != nil {
+		return hash, &types.Web3Error{Code: -32602, Message: "invalid argument 0: " + err.Error()}
+	}
+	return hash, nil
+}
+
+func buildGethResponse(i types.Web3ClientRequest, result any) gin.H {
 	return gin.H{
 		"jsonrpc": i.JsonRPC,
 		"id":      i.Id,
@@ -124,9 +337,17 @@ func buildGethResponse(i types.Web3ClientRequest, result string) gin.H {
 	}
 }
 
+func buildGethError(i types.Web3ClientRequest, err *types.Web3Error) gin.H {
+	return gin.H{
+		"jsonrpc": i.JsonRPC,
+		"id":      i.Id,
+		"error":   err,
+	}
+}
+
 // 如果用户请求特定的方法，我们可以直接返回 0x30 作为响应
 func decodeEthCall(p []any) (s string, b bool) {
-	if len(p) > 2 {
+	if len(p) == 0 || len(p) > 2 {
 		return
 	}
 	v, ok := p[0].(map[string]any)
@@ -2,9 +2,11 @@ package tools
 
 import (
 	"net"
+	"net/netip"
 	"strings"
 
-	"github.com/48Club/service_agent/cloudflare"
+	"github.com/48Club/service_agent/config"
+	"github.com/48Club/service_agent/server"
 	"github.com/gin-gonic/gin"
 )
 
@@ -25,8 +27,40 @@ func FormatIP(s string) string { // check ipv4, or format ipv6 to cidr64
 	return s
 }
 
+// CheckGinIP 返回格式化后的客户端 IP, 以及连接是否来自受信任的 CDN 节点或直连白名单
 func CheckGinIP(c *gin.Context) (string, bool) {
-	remoteIP, userIp := FormatIP(c.RemoteIP()), FormatIP(c.ClientIP())
+	addr, trusted := ClientAddr(c)
+	if !trusted {
+		return "", false
+	}
+	return FormatIP(addr.String()), true
+}
+
+// ClientAddr 按域名的信任配置解析客户端 IP
+// 配置了 cdn_platforms 时沿用 gin 的 TrustedPlatform, 信任所有连接
+// 经过 PROXY protocol 的连接以头中的地址作为对端地址, 同样需要属于 CDN 节点或直连白名单
+// trust 为 local 的监听地址上对端即客户端
+func ClientAddr(c *gin.Context) (netip.Addr, bool) {
+	if l := server.ListenerOf(c.Request.Context()); l != nil && l.Local {
+		if addr, ok := server.ProxiedAddr(c.Request.Context()); ok {
+			return addr, true
+		}
+		return server.LocalAddr(c.RemoteIP()), true
+	}
 
-	return userIp, cloudflare.IsCloudflareIP(remoteIP)
+	if config.Get().CDNPlatforms != "" {
+		addr, err := netip.ParseAddr(c.ClientIP())
+		return addr.Unmap(), err == nil
+	}
+
+	trust := config.Get().TrustFor(c.Request.Host)
+	if remote, ok := server.ProxiedAddr(c.Request.Context()); ok {
+		return trust.Resolve(remote, c.Request.Header)
+	}
+
+	remote, err := netip.ParseAddr(c.RemoteIP())
+	if err != nil {
+		return netip.Addr{}, false
+	}
+	return trust.Resolve(remote, c.Request.Header)
 }
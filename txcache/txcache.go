@@ -0,0 +1,155 @@
+package txcache
+
+import (
+	"context"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/48Club/service_agent/metrics"
+	"github.com/48Club/service_agent/types"
+	"github.com/ethereum/go-ethereum/common"
+)
+
+// Entry 交易上一次提交的结果, Err 非 nil 表示节点拒绝了交易
+type Entry struct {
+	Hash common.Hash
+	Err  *types.Web3Error
+	at   time.Time
+}
+
+type nonceKey struct {
+	sender common.Address
+	nonce  uint64
+}
+
+// replacements 同一 sender+nonce 在窗口内提交过的不同交易
+type replacements struct {
+	start  time.Time
+	hashes []common.Hash
+}
+
+// Cache 按交易哈希缓存提交结果, 并限制同一 sender+nonce 的替换次数
+type Cache struct {
+	mu              sync.Mutex
+	ttl             time.Duration // 0 表示不缓存
+	maxReplacements int           // 0 表示不限制
+	window          time.Duration
+	txs             map[common.Hash]Entry
+	nonces          map[nonceKey]*replacements
+}
+
+func New() *Cache {
+	return &Cache{txs: map[common.Hash]Entry{}, nonces: map[nonceKey]*replacements{}}
+}
+
+var Txs = New()
+
+func (c *Cache) Configure(ttl time.Duration, maxReplacements int, window time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.ttl, c.maxReplacements, c.window = ttl, maxReplacements, window
+}
+
+func (c *Cache) Enabled() bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.ttl > 0
+}
+
+// Get 返回未过期的提交结果
+func (c *Cache) Get(hash common.Hash) (Entry, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.ttl <= 0 {
+		return Entry{}, false
+	}
+	e, ok := c.txs[hash]
+	if ok && time.Since(e.at) > c.ttl {
+		delete(c.txs, hash)
+		ok = false
+	}
+	metrics.CacheHit("raw_tx", ok)
+	return e, ok
+}
+
+// Store 记录提交结果, 只应缓存节点明确返回的结果, 超时等临时错误不缓存
+func (c *Cache) Store(hash, result common.Hash, err *types.Web3Error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.ttl <= 0 {
+		return
+	}
+	c.txs[hash] = Entry{Hash: result, Err: err, at: time.Now()}
+}
+
+// Replace 记录 sender+nonce 的一次提交, 窗口内不同交易的数量超过上限时返回 false
+// 重复提交同一笔交易不计数
+func (c *Cache) Replace(sender common.Address, nonce uint64, hash common.Hash) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.maxReplacements <= 0 {
+		return true
+	}
+
+	now := time.Now()
+	k := nonceKey{sender, nonce}
+	r, ok := c.nonces[k]
+	if !ok || now.Sub(r.start) > c.window {
+		r = &replacements{start: now}
+		c.nonces[k] = r
+	}
+	for _, h := range r.hashes {
+		if h == hash {
+			return true
+		}
+	}
+	// 第一笔交易不算替换
+	if len(r.hashes) > c.maxReplacements {
+		return false
+	}
+	r.hashes = append(r.hashes, hash)
+	return true
+}
+
+// Release 交易未被接受时归还 Replace 占用的次数
+func (c *Cache) Release(sender common.Address, nonce uint64, hash common.Hash) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	r, ok := c.nonces[nonceKey{sender, nonce}]
+	if !ok {
+		return
+	}
+	r.hashes = slices.DeleteFunc(r.hashes, func(h common.Hash) bool { return h == hash })
+}
+
+func (c *Cache) sweep(now time.Time) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for hash, e := range c.txs {
+		if now.Sub(e.at) > c.ttl {
+			delete(c.txs, hash)
+		}
+	}
+	for k, r := range c.nonces {
+		if now.Sub(r.start) > c.window {
+			delete(c.nonces, k)
+		}
+	}
+}
+
+// Start 定期清理过期记录, ctx 取消后退出
+func Start(ctx context.Context, interval time.Duration) {
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case now := <-ticker.C:
+				Txs.sweep(now)
+			}
+		}
+	}()
+}
@@ -0,0 +1,62 @@
+package txcache
+
+import (
+	"testing"
+	"time"
+
+	"github.com/48Club/service_agent/types"
+	"github.com/ethereum/go-ethereum/common"
+)
+
+func TestCache(t *testing.T) {
+	c := New()
+	hash := common.HexToHash("0x48")
+	c.Store(hash, hash, nil)
+	if _, ok := c.Get(hash); ok {
+		t.Fatal("disabled cache returned entry")
+	}
+
+	c.Configure(50*time.Millisecond, 0, 0)
+	c.Store(hash, hash, nil)
+	if e, ok := c.Get(hash); !ok || e.Hash != hash || e.Err != nil {
+		t.Fatalf("got %+v, %v", e, ok)
+	}
+	rejected := common.HexToHash("0x49")
+	c.Store(rejected, common.Hash{}, &types.Web3Error{Code: -32000, Message: "nonce too low"})
+	if e, ok := c.Get(rejected); !ok || e.Err == nil || e.Err.Message != "nonce too low" {
+		t.Fatalf("got %+v, %v", e, ok)
+	}
+
+	time.Sleep(60 * time.Millisecond)
+	if _, ok := c.Get(hash); ok {
+		t.Fatal("expired entry returned")
+	}
+}
+
+func TestReplace(t *testing.T) {
+	c := New()
+	c.Configure(time.Minute, 2, 50*time.Millisecond)
+	sender := common.HexToAddress("0x48")
+
+	for i, want := range []bool{true, true, true, false} {
+		if got := c.Replace(sender, 1, common.BytesToHash([]byte{byte(i + 1)})); got != want {
+			t.Fatalf("attempt %d: got %v", i, got)
+		}
+	}
+	if !c.Replace(sender, 1, common.BytesToHash([]byte{1})) {
+		t.Fatal("resubmission counted as replacement")
+	}
+	// 被拒绝的交易不占用次数
+	c.Release(sender, 1, common.BytesToHash([]byte{2}))
+	if !c.Replace(sender, 1, common.BytesToHash([]byte{4})) {
+		t.Fatal("released slot not reused")
+	}
+	if !c.Replace(sender, 2, common.HexToHash("0x1")) {
+		t.Fatal("other nonce limited")
+	}
+
+	time.Sleep(60 * time.Millisecond)
+	if !c.Replace(sender, 1, common.HexToHash("0x10")) {
+		t.Fatal("window not reset")
+	}
+}
@@ -17,6 +17,14 @@ func (w Web3ClientRequest) Conv2Batch() Web3ClientRequests { return Web3ClientRe
 
 type Web3ClientRequests []Web3ClientRequest
 
+// Web3Error JSON-RPC 错误, 由 agent 直接返回给客户端
+type Web3Error struct {
+	Code    int    `json:"code"`
+	Message string `json:"message"`
+}
+
+func (e *Web3Error) Error() string { return e.Message }
+
 type LimitResponse struct {
 	Limit     HeaderStrs
 	Remaining HeaderStrs
@@ -30,6 +38,9 @@ func (l LimitResponse) AddHeader(c *gin.Context) {
 type HeaderStrs []string
 
 func (s HeaderStrs) ToString() string {
+	if len(s) == 0 {
+		return "[]"
+	}
 	b, err := json.Marshal(s)
 	if err != nil {
 		return "[]"
@@ -0,0 +1,337 @@
+package upstream
+
+import (
+	"context"
+	"log"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/48Club/service_agent/metrics"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/ethclient"
+	"github.com/ethereum/go-ethereum/rpc"
+)
+
+// Health 后台轮询得到的上游状态快照
+type Health struct {
+	Healthy   bool          `json:"healthy"`
+	ChainID   uint64        `json:"chain_id"`
+	Head      uint64        `json:"head"`
+	HeadTime  time.Time     `json:"head_time"`
+	HeadAge   time.Duration `json:"head_age"`
+	Safe      uint64        `json:"safe,omitempty"`      // 节点不支持 safe 标签时为 0
+	Finalized uint64        `json:"finalized,omitempty"` // 节点不支持 finalized 标签时为 0
+	Peers     uint64        `json:"peers"`
+	CheckedAt time.Time     `json:"checked_at"`
+	Error     string        `json:"error,omitempty"`
+}
+
+// Thresholds 健康判断阈值, 按链配置
+type Thresholds struct {
+	MaxHeadAge time.Duration // 头区块超过该时长未更新, 认为不可用
+	MinPeers   uint64        // 节点 peer 数低于该值, 认为不可用
+}
+
+var DefaultThresholds = Thresholds{MaxHeadAge: 20 * time.Second}
+
+type Upstream struct {
+	Name string
+	URL  string
+	WS   string
+
+	disabled atomic.Bool // 被摘除的上游不再接收新请求, 但仍继续健康检查
+
+	mu     sync.RWMutex
+	health Health
+
+	clientMu sync.Mutex
+	client   *ethclient.Client
+}
+
+func New(name, url, ws string) *Upstream {
+	return &Upstream{Name: name, URL: url, WS: ws}
+}
+
+func (u *Upstream) Enabled() bool { return !u.disabled.Load() }
+
+// SetEnabled 摘除 (drain) 或恢复上游
+func (u *Upstream) SetEnabled(enabled bool) {
+	if u.disabled.Swap(!enabled) == !enabled {
+		return
+	}
+	log.Printf("upstream %s enabled: %v", u.Name, enabled)
+}
+
+func (u *Upstream) Health() Health {
+	u.mu.RLock()
+	defer u.mu.RUnlock()
+	return u.health
+}
+
+// Client 返回连接上游的 ethclient, 首次使用时建立连接
+func (u *Upstream) Client(ctx context.Context) (*ethclient.Client, error) {
+	u.clientMu.Lock()
+	defer u.clientMu.Unlock()
+	if u.client == nil {
+		client, err := ethclient.DialContext(ctx, u.URL)
+		if err != nil {
+			return nil, err
+		}
+		u.client = client
+	}
+	return u.client, nil
+}
+
+func (u *Upstream) setHealth(h Health) {
+	u.mu.Lock()
+	u.health = h
+	u.mu.Unlock()
+}
+
+type Pool struct {
+	upstreams  []*Upstream
+	thresholds map[uint64]Thresholds // chainId => 阈值
+	interval   time.Duration
+	next       atomic.Uint64
+
+	pinMu  sync.Mutex
+	pinned Heads // AgreedHeads 已返回的最大值
+}
+
+func NewPool(upstreams []*Upstream, thresholds map[uint64]Thresholds, interval time.Duration) *Pool {
+	if interval <= 0 {
+		interval = 3 * time.Second
+	}
+	return &Pool{upstreams: upstreams, thresholds: thresholds, interval: interval}
+}
+
+func (p *Pool) Upstreams() []*Upstream { return p.upstreams }
+
+func (p *Pool) Get(name string) *Upstream {
+	for _, u := range p.upstreams {
+		if u.Name == name {
+			return u
+		}
+	}
+	return nil
+}
+
+// Pick 轮询选择一个健康的上游, 全部不健康时退化为轮询所有启用的上游, 全部被摘除时不再过滤
+func (p *Pool) Pick() *Upstream {
+	n := uint64(len(p.upstreams))
+	start := p.next.Add(1)
+	for i := range n {
+		u := p.upstreams[(start+i)%n]
+		if u.Enabled() && u.Health().Healthy {
+			return u
+		}
+	}
+	for i := range n {
+		u := p.upstreams[(start+i)%n]
+		if u.Enabled() {
+			return u
+		}
+	}
+	return p.upstreams[start%n]
+}
+
+// ChainID 返回上游节点的 chain id, 尚未轮询到时返回 0
+func (p *Pool) ChainID() uint64 {
+	for _, u := range p.upstreams {
+		if id := u.Health().ChainID; id != 0 {
+			return id
+		}
+	}
+	return 0
+}
+
+// Head 返回启用且健康的上游中最高的头区块, 没有时返回 0
+func (p *Pool) Head() uint64 {
+	var head uint64
+	for _, u := range p.upstreams {
+		if h := u.Health(); u.Enabled() && h.Healthy {
+			head = max(head, h.Head)
+		}
+	}
+	return head
+}
+
+// Heads 所有上游都已达到的区块号, 用于改写 latest, safe 和 finalized 标签
+type Heads struct {
+	Latest, Safe, Finalized uint64
+}
+
+// AgreedHeads 返回启用且健康的上游中最慢的各标签区块号, 任一上游不支持该标签时为 0
+// latest 不会回退: 头区块低于已返回值的上游 (如刚恢复健康) 不参与计算, 改写后的请求由 PickAt 避开这些上游
+// 所有健康的上游都落后时 (如最快的上游故障) 从最慢的上游重新开始, 没有健康的上游时返回 0, 不改写
+func (p *Pool) AgreedHeads() Heads {
+	p.pinMu.Lock()
+	defer p.pinMu.Unlock()
+
+	heads, ok := p.agreed(p.pinned.Latest)
+	if !ok {
+		heads, ok = p.agreed(0)
+		p.pinned = heads
+		return heads
+	}
+	p.pinned.Latest = heads.Latest
+	p.pinned.Safe = pinTag(p.pinned.Safe, heads.Safe)
+	p.pinned.Finalized = pinTag(p.pinned.Finalized, heads.Finalized)
+	return p.pinned
+}
+
+// agreed 头区块不低于 minHead 的启用且健康的上游中最慢的各标签区块号, 没有这样的上游时 ok 为 false
+func (p *Pool) agreed(minHead uint64) (heads Heads, ok bool) {
+	for _, u := range p.upstreams {
+		h := u.Health()
+		if !u.Enabled() || !h.Healthy || h.Head < minHead {
+			continue
+		}
+		if !ok {
+			heads, ok = Heads{h.Head, h.Safe, h.Finalized}, true
+			continue
+		}
+		heads.Latest = min(heads.Latest, h.Head)
+		heads.Safe = min(heads.Safe, h.Safe)
+		heads.Finalized = min(heads.Finalized, h.Finalized)
+	}
+	return
+}
+
+// pinTag safe 和 finalized 不回退, 但有上游不再支持该标签时为 0
+func pinTag(pinned, agreed uint64) uint64 {
+	if agreed == 0 {
+		return 0
+	}
+	return max(pinned, agreed)
+}
+
+// PickAt 轮询选择头区块不低于 head 的健康上游, 用于区块标签已改写为 head 的请求, 没有时退化为 Pick
+func (p *Pool) PickAt(head uint64) *Upstream {
+	if head == 0 {
+		return p.Pick()
+	}
+	n := uint64(len(p.upstreams))
+	start := p.next.Add(1)
+	for i := range n {
+		u := p.upstreams[(start+i)%n]
+		if h := u.Health(); u.Enabled() && h.Healthy && h.Head >= head {
+			return u
+		}
+	}
+	return p.Pick()
+}
+
+// Client 返回 Pick 选中的上游的 ethclient, 用于 agent 自己查询链上状态
+func (p *Pool) Client(ctx context.Context) (*ethclient.Client, error) {
+	return p.Pick().Client(ctx)
+}
+
+// Ready 至少有一个启用的上游健康且缓存的状态未过期
+func (p *Pool) Ready() bool {
+	for _, u := range p.upstreams {
+		h := u.Health()
+		if u.Enabled() && h.Healthy && time.Since(h.CheckedAt) < 3*p.interval {
+			return true
+		}
+	}
+	return false
+}
+
+// Start 启动后台轮询, ctx 取消后退出
+func (p *Pool) Start(ctx context.Context) {
+	for _, u := range p.upstreams {
+		go func() {
+			ticker := time.NewTicker(p.interval)
+			defer ticker.Stop()
+			for {
+				p.check(ctx, u)
+				select {
+				case <-ctx.Done():
+					return
+				case <-ticker.C:
+				}
+			}
+		}()
+	}
+}
+
+func (p *Pool) check(ctx context.Context, u *Upstream) {
+	ctx, cancel := context.WithTimeout(ctx, p.interval)
+	defer cancel()
+
+	h, err := u.poll(ctx)
+	if err != nil {
+		h.Error = err.Error()
+	} else {
+		th, ok := p.thresholds[h.ChainID]
+		if !ok {
+			th = DefaultThresholds
+		}
+		h.Healthy = h.HeadAge <= th.MaxHeadAge && h.Peers >= th.MinPeers
+	}
+	h.CheckedAt = time.Now()
+
+	if prev := u.Health(); prev.Healthy != h.Healthy && !prev.CheckedAt.IsZero() {
+		log.Printf("upstream %s healthy: %v -> %v, head: %d, age: %s, err: %s", u.Name, prev.Healthy, h.Healthy, h.Head, h.HeadAge, h.Error)
+	}
+	u.setHealth(h)
+
+	if h.Healthy {
+		metrics.UpstreamUp.WithLabelValues(u.Name).Set(1)
+	} else {
+		metrics.UpstreamUp.WithLabelValues(u.Name).Set(0)
+	}
+	if err == nil {
+		metrics.UpstreamHeadBlock.WithLabelValues(u.Name).Set(float64(h.Head))
+		metrics.UpstreamHeadLag.WithLabelValues(u.Name).Set(h.HeadAge.Seconds())
+	}
+}
+
+func (u *Upstream) poll(ctx context.Context) (h Health, err error) {
+	client, err := u.Client(ctx)
+	if err != nil {
+		return
+	}
+
+	if h.ChainID = u.Health().ChainID; h.ChainID == 0 {
+		chainId, err := client.ChainID(ctx)
+		if err != nil {
+			return h, err
+		}
+		h.ChainID = chainId.Uint64()
+	}
+
+	header, err := client.HeaderByNumber(ctx, nil)
+	if err != nil {
+		return
+	}
+	h.Head = header.Number.Uint64()
+	h.HeadTime = time.Unix(int64(header.Time), 0)
+	h.HeadAge = time.Since(h.HeadTime)
+
+	// safe 和 finalized 查询失败时记为 0, 不影响健康判断
+	var safe, finalized *struct {
+		Number hexutil.Uint64 `json:"number"`
+	}
+	batch := []rpc.BatchElem{
+		{Method: "eth_getBlockByNumber", Args: []any{"safe", false}, Result: &safe},
+		{Method: "eth_getBlockByNumber", Args: []any{"finalized", false}, Result: &finalized},
+	}
+	if client.Client().BatchCallContext(ctx, batch) == nil {
+		if batch[0].Error == nil && safe != nil {
+			h.Safe = uint64(safe.Number)
+		}
+		if batch[1].Error == nil && finalized != nil {
+			h.Finalized = uint64(finalized.Number)
+		}
+	}
+
+	// 部分节点未开放 net 命名空间, 查询失败时 peer 数记为 0
+	var peers hexutil.Uint64
+	if client.Client().CallContext(ctx, &peers, "net_peerCount") == nil {
+		h.Peers = uint64(peers)
+	}
+	return
+}
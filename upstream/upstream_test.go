@@ -0,0 +1,71 @@
+package upstream
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPick(t *testing.T) {
+	a, b := New("a", "http://a", "ws://a"), New("b", "http://b", "ws://b")
+	p := NewPool([]*Upstream{a, b}, nil, time.Second)
+	assert.False(t, p.Ready())
+
+	b.setHealth(Health{Healthy: true, CheckedAt: time.Now()})
+	for range 4 {
+		assert.Equal(t, "b", p.Pick().Name)
+	}
+	assert.True(t, p.Ready())
+
+	// 被摘除的上游不再被选中
+	a.setHealth(Health{Healthy: true, CheckedAt: time.Now()})
+	b.SetEnabled(false)
+	for range 4 {
+		assert.Equal(t, "a", p.Pick().Name)
+	}
+	b.SetEnabled(true)
+
+	// 缓存过期后不再认为就绪
+	a.setHealth(Health{})
+	b.setHealth(Health{Healthy: true, CheckedAt: time.Now().Add(-time.Minute)})
+	assert.False(t, p.Ready())
+}
+
+func TestAgreedHeads(t *testing.T) {
+	a, b, c := New("a", "http://a", "ws://a"), New("b", "http://b", "ws://b"), New("c", "http://c", "ws://c")
+	p := NewPool([]*Upstream{a, b, c}, nil, time.Second)
+	assert.Equal(t, Heads{}, p.AgreedHeads())
+
+	a.setHealth(Health{Healthy: true, Head: 100, Safe: 98, Finalized: 97})
+	b.setHealth(Health{Healthy: true, Head: 102, Safe: 99, Finalized: 96})
+	c.setHealth(Health{Head: 90})
+	assert.Equal(t, Heads{100, 98, 96}, p.AgreedHeads())
+	assert.Equal(t, uint64(102), p.Head())
+
+	// 恢复健康但落后的上游不会使结果回退, 改写后的请求也不会发给它
+	c.setHealth(Health{Healthy: true, Head: 95, Safe: 93, Finalized: 92})
+	assert.Equal(t, Heads{100, 98, 96}, p.AgreedHeads())
+	for range 6 {
+		assert.NotEqual(t, "c", p.PickAt(100).Name)
+	}
+
+	// 被摘除的上游不参与计算
+	a.SetEnabled(false)
+	c.setHealth(Health{Healthy: true, Head: 101, Safe: 99, Finalized: 98})
+	assert.Equal(t, Heads{101, 99, 96}, p.AgreedHeads())
+
+	// 有上游不再支持 finalized 时不保留旧值
+	c.setHealth(Health{Healthy: true, Head: 103, Safe: 100})
+	assert.Equal(t, Heads{102, 99, 0}, p.AgreedHeads())
+
+	// 所有健康的上游都落后时从最慢的上游重新开始
+	b.setHealth(Health{})
+	c.setHealth(Health{Healthy: true, Head: 90, Safe: 88, Finalized: 87})
+	assert.Equal(t, Heads{90, 88, 87}, p.AgreedHeads())
+	assert.Equal(t, "c", p.PickAt(90).Name)
+
+	// 没有健康的上游时不改写
+	c.setHealth(Health{})
+	assert.Equal(t, Heads{}, p.AgreedHeads())
+}